@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"req2proto/probe"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// manifest lists the endpoints of `req2proto batch`
+type manifest struct {
+	Endpoints []manifestEndpoint `yaml:"endpoints"`
+}
+
+// manifestEndpoint is an endpoint of the manifest, the fields that are left out default to the flags
+type manifestEndpoint struct {
+	URL     string            `yaml:"url"`
+	Method  string            `yaml:"method"`
+	Headers map[string]string `yaml:"headers"`
+	Type    string            `yaml:"type"`
+	Depth   *int              `yaml:"depth"`
+}
+
+// batchMain runs `req2proto batch`, which probes every endpoint of a manifest into a single output.
+// It returns the exit code, which is 1 when an endpoint failed.
+func batchMain(args []string) int {
+	fs := flag.NewFlagSet("batch", flag.ExitOnError)
+	opts := registerFlags(fs)
+
+	// the manifest can come before or after the flags
+	manifestPath := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		manifestPath = args[0]
+		args = args[1:]
+	}
+	fs.Parse(args)
+	if manifestPath == "" {
+		manifestPath = fs.Arg(0)
+	}
+
+	logFile := setupLogger()
+	defer logFile.Close()
+
+	if manifestPath == "" {
+		panic("no manifest supplied!")
+	}
+	if opts.checkpointDir != "" || opts.resumeDir != "" {
+		logger.Fatal().Msg("checkpoints aren't supported in batch mode")
+	}
+
+	m, err := loadManifest(manifestPath)
+	if err != nil {
+		logger.Fatal().Err(err).Str("manifest", manifestPath).Msg("unable to load manifest")
+	}
+
+	endpoints := make([]probe.Endpoint, 0, len(m.Endpoints))
+	for i, entry := range m.Endpoints {
+		if entry.URL == "" {
+			logger.Fatal().Int("endpoint", i+1).Msg("every endpoint of the manifest needs a url")
+		}
+
+		var endpointOpts []probe.Option
+		if entry.Method != "" {
+			endpointOpts = append(endpointOpts, probe.WithMethod(entry.Method))
+		}
+		if len(entry.Headers) > 0 {
+			endpointOpts = append(endpointOpts, probe.WithHeaders(entry.Headers))
+		}
+		if entry.Depth != nil {
+			endpointOpts = append(endpointOpts, probe.WithMaxDepth(*entry.Depth))
+		}
+		endpoints = append(endpoints, probe.Endpoint{URL: entry.URL, RootType: entry.Type, Options: endpointOpts})
+	}
+
+	imported := importedFiles(opts)
+	descSet, results := newProber(opts).ProbeBatch(context.Background(), endpoints)
+	writeOutput(opts, descSet, imported)
+
+	return printBatchSummary(results)
+}
+
+func loadManifest(path string) (*manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// unknown keys are most likely typos, which would silently fall back to the flags otherwise
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+
+	m := &manifest{}
+	if err := decoder.Decode(m); err != nil {
+		return nil, err
+	}
+	if len(m.Endpoints) == 0 {
+		return nil, fmt.Errorf("no endpoints in manifest")
+	}
+	return m, nil
+}
+
+// printBatchSummary prints a table of the requests and failures of every endpoint, and returns the exit code
+func printBatchSummary(results []probe.EndpointResult) int {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tURL\tREQUESTS\tFAILURES\tRESULT")
+
+	failed := 0
+	for _, result := range results {
+		rootType := result.RootType
+		if rootType == "" {
+			rootType = result.Endpoint.RootType
+		}
+		if rootType == "" {
+			// it couldn't be inferred
+			rootType = "-"
+		}
+
+		status := "ok"
+		if result.Err != nil {
+			// errors can include the response body, which would break the table
+			status = "error: " + strings.Join(strings.Fields(result.Err.Error()), " ")
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n", rootType, result.Endpoint.URL, result.Requests, result.Failures, status)
+	}
+	w.Flush()
+	fmt.Printf("%d endpoints, %d failed\n", len(results), failed)
+
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
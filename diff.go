@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"req2proto/probe"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// diffMain runs `req2proto diff`, which probes the endpoint again and compares the result against a previous output.
+// It returns the exit code, which is 2 when there are breaking changes.
+func diffMain(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	opts := registerFlags(fs)
+	oldPath := fs.String("old", "", "Previous output to compare against (output directory, .proto file or descriptor set)")
+	jsonOutput := fs.Bool("json", false, "Print the changes as JSON instead of text")
+	fs.Parse(args)
+
+	logFile := setupLogger()
+	defer logFile.Close()
+
+	if opts.url == "" {
+		panic("no url supplied!")
+	}
+	if *oldPath == "" {
+		panic("no previous output supplied! specify one with -old")
+	}
+
+	// the previous output may import the types of -import-path, and google/api/annotations.proto with --services
+	oldDescs, err := probe.LoadDescriptorPath(*oldPath, importRoots(opts.importPaths)...)
+	if err != nil {
+		logger.Fatal().Err(err).Str("path", *oldPath).Msg("unable to load previous output")
+	}
+	var oldFiles []*descriptorpb.FileDescriptorProto
+	for _, fd := range oldDescs {
+		if !strings.HasPrefix(fd.Path(), "google/protobuf/") {
+			oldFiles = append(oldFiles, protodesc.ToFileDescriptorProto(fd))
+		}
+	}
+
+	// the new output must not overwrite the previous one, which is what -o is by default
+	if overlaps(opts.outputDir, *oldPath) {
+		logger.Warn().Str("dir", opts.outputDir).Str("old", *oldPath).Msg("not writing .proto files, as the output directory holds the previous output")
+		opts.outputDir = ""
+	}
+	if opts.descriptorSetOut != "" && overlaps(opts.descriptorSetOut, *oldPath) {
+		logger.Warn().Str("file", opts.descriptorSetOut).Str("old", *oldPath).Msg("not writing the descriptor set, as it's the previous output")
+		opts.descriptorSetOut = ""
+	}
+
+	newSet := run(opts)
+	changes := probe.DiffSchemas(oldFiles, newSet.File)
+
+	breaking := 0
+	for _, change := range changes {
+		if change.Breaking {
+			breaking++
+		}
+	}
+
+	if *jsonOutput {
+		b, err := json.MarshalIndent(changes, "", "  ")
+		if err != nil {
+			logger.Fatal().Err(err).Msg("unable to marshal changes")
+		}
+		fmt.Println(string(b))
+	} else {
+		for _, change := range changes {
+			severity := ""
+			if change.Breaking {
+				severity = "BREAKING"
+			}
+
+			details := ""
+			switch {
+			case change.Old != "" && change.New != "":
+				details = fmt.Sprintf(" (%s -> %s)", change.Old, change.New)
+			case change.Old != "":
+				details = fmt.Sprintf(" (%s)", change.Old)
+			case change.New != "":
+				details = fmt.Sprintf(" (%s)", change.New)
+			}
+
+			fmt.Printf("%-8s %-20s %s%s\n", severity, change.Kind, change.Element, details)
+		}
+		fmt.Printf("%d changes, %d breaking\n", len(changes), breaking)
+	}
+
+	if breaking > 0 {
+		return 2
+	}
+	return 0
+}
+
+// importRoots returns the directories to resolve the imports of .proto files from, descriptor sets don't have any
+func importRoots(paths []string) []string {
+	var roots []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		switch {
+		case err != nil:
+			continue
+		case info.IsDir():
+			roots = append(roots, path)
+		case filepath.Ext(path) == ".proto":
+			roots = append(roots, filepath.Dir(path))
+		}
+	}
+	return roots
+}
+
+// overlaps tells whether one of the paths is the other or is inside of it
+func overlaps(a string, b string) bool {
+	a, errA := filepath.Abs(a)
+	b, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return a == b || strings.HasPrefix(a, b+string(filepath.Separator)) || strings.HasPrefix(b, a+string(filepath.Separator))
+}
@@ -0,0 +1,67 @@
+package gapitest
+
+import (
+	"encoding/base64"
+	"strconv"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// marshalJSPB encodes a message as JSPB, where position n-1 holds field n and unset positions are null. 64-bit
+// integers are strings, bytes are base64 and maps are arrays of [key, value] pairs.
+func marshalJSPB(m protoreflect.Message) []interface{} {
+	var values []interface{}
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		for len(values) < int(fd.Number()) {
+			values = append(values, nil)
+		}
+
+		switch {
+		case fd.IsMap():
+			var entries []interface{}
+			v.Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+				entries = append(entries, []interface{}{jspbValue(fd.MapKey(), k.Value()), jspbValue(fd.MapValue(), v)})
+				return true
+			})
+			values[fd.Number()-1] = entries
+		case fd.IsList():
+			list := make([]interface{}, v.List().Len())
+			for i := range list {
+				list[i] = jspbValue(fd, v.List().Get(i))
+			}
+			values[fd.Number()-1] = list
+		default:
+			values[fd.Number()-1] = jspbValue(fd, v)
+		}
+		return true
+	})
+	if values == nil {
+		return []interface{}{}
+	}
+	return values
+}
+
+func jspbValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) interface{} {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return marshalJSPB(v.Message())
+	case protoreflect.EnumKind:
+		return int32(v.Enum())
+	case protoreflect.BytesKind:
+		return base64.StdEncoding.EncodeToString(v.Bytes())
+	case protoreflect.StringKind:
+		return v.String()
+	case protoreflect.BoolKind:
+		return v.Bool()
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return v.Float()
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return v.Uint()
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		// 64-bit integers are strings, like in JSON
+		return strconv.FormatUint(v.Uint(), 10)
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return strconv.FormatInt(v.Int(), 10)
+	}
+	return v.Int()
+}
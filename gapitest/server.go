@@ -0,0 +1,780 @@
+// Package gapitest provides a fake Google API frontend, which answers JSPB (application/json+protobuf) and JSON
+// (application/json) payloads for a known request message with the same field violations a real frontend would.
+package gapitest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const (
+	badRequestTypeURL = "type.googleapis.com/google.rpc.BadRequest"
+	errorInfoTypeURL  = "type.googleapis.com/google.rpc.ErrorInfo"
+)
+
+// Server is an http.Handler validating payloads against the request message. Requests with violations are answered
+// with a 400 and a google.rpc.Status, as JSON, as binary protobuf with alt=proto or as JSPB with alt=protojson.
+// Valid requests get an empty 200.
+type Server struct {
+	// Method is the gRPC method reported in a google.rpc.ErrorInfo of every error (ex.
+	// google.internal.people.v2.InternalPeopleService.InsertPerson), there's no ErrorInfo if it's empty
+	Method string
+	// HideRequestType keeps the request type out of the violation of a payload that isn't an array, like some
+	// frontends do
+	HideRequestType bool
+	// Response is answered to valid requests: as JSPB with alt=protojson, binary protobuf with alt=proto and JSON
+	// otherwise. Valid requests get an empty response if it's nil.
+	Response proto.Message
+	// PositionalPaths reports fields by number instead of by name in the violation paths, like some frontends do
+	PositionalPaths bool
+	// JSPBErrors answers every error as JSPB (application/json+protobuf) whatever the alt parameter, like the
+	// frontends that only speak JSPB
+	JSPBErrors bool
+	// SerializedMessages names the message held serialized by bytes fields, by full name of the field (ex.
+	// google.example.Request.state: google.example.State). Bytes that don't parse as that message are rejected with
+	// its type URL.
+	SerializedMessages map[string]string
+	// UntypedViolations leaves the type out of the violations of scalar values ("Invalid value at 'count', 1.5"),
+	// like the frontends that only say the value isn't a number
+	UntypedViolations bool
+	// MaxViolations reports at most that many violations of a request, like the frontends that stop at the first
+	// one. All of them are reported if it's 0.
+	MaxViolations int
+
+	request protoreflect.MessageDescriptor
+	files   *protoregistry.Files
+	// the extensions defined in the set, by extendee and number
+	extensions map[protoreflect.FullName]map[protoreflect.FieldNumber]protoreflect.FieldDescriptor
+}
+
+// NewServer creates a server for requestType, which has to be defined in set. Well-known types don't have to be
+// part of the set.
+func NewServer(set *descriptorpb.FileDescriptorSet, requestType string) (*Server, error) {
+	files, err := protodesc.NewFiles(withImports(set))
+	if err != nil {
+		return nil, err
+	}
+
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(requestType))
+	if err != nil {
+		return nil, fmt.Errorf("unable to find request type %s: %w", requestType, err)
+	}
+	request, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message", requestType)
+	}
+
+	server := &Server{request: request, files: files, extensions: make(map[protoreflect.FullName]map[protoreflect.FieldNumber]protoreflect.FieldDescriptor)}
+	files.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+		server.addExtensions(fd.Extensions(), fd.Messages())
+		return true
+	})
+	return server, nil
+}
+
+// addExtensions registers the extensions, along with the ones nested in the messages
+func (s *Server) addExtensions(extensions protoreflect.ExtensionDescriptors, messages protoreflect.MessageDescriptors) {
+	for i := 0; i < extensions.Len(); i++ {
+		ext := extensions.Get(i)
+		extendee := ext.ContainingMessage().FullName()
+		if s.extensions[extendee] == nil {
+			s.extensions[extendee] = make(map[protoreflect.FieldNumber]protoreflect.FieldDescriptor)
+		}
+		s.extensions[extendee][ext.Number()] = ext
+	}
+	for i := 0; i < messages.Len(); i++ {
+		s.addExtensions(messages.Get(i).Extensions(), messages.Get(i).Messages())
+	}
+}
+
+// withImports adds the files the set imports from the global registry (ex. google/protobuf/timestamp.proto)
+func withImports(set *descriptorpb.FileDescriptorSet) *descriptorpb.FileDescriptorSet {
+	result := &descriptorpb.FileDescriptorSet{File: append([]*descriptorpb.FileDescriptorProto(nil), set.File...)}
+	present := make(map[string]bool)
+	for _, fdproto := range set.File {
+		present[fdproto.GetName()] = true
+	}
+
+	var add func(fd protoreflect.FileDescriptor)
+	add = func(fd protoreflect.FileDescriptor) {
+		if present[fd.Path()] {
+			return
+		}
+		present[fd.Path()] = true
+		for i := 0; i < fd.Imports().Len(); i++ {
+			add(fd.Imports().Get(i).FileDescriptor)
+		}
+		result.File = append(result.File, protodesc.ToFileDescriptorProto(fd))
+	}
+
+	for _, fdproto := range set.File {
+		for _, dep := range fdproto.Dependency {
+			if fd, err := protoregistry.GlobalFiles.FindFileByPath(dep); err == nil {
+				add(fd)
+			}
+		}
+	}
+	return result
+}
+
+// FieldViolation is a google.rpc.BadRequest.FieldViolation
+type FieldViolation struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+type errorResponse struct {
+	Error struct {
+		Code    int           `json:"code"`
+		Message string        `json:"message"`
+		Status  string        `json:"status"`
+		Details []interface{} `json:"details,omitempty"`
+	} `json:"error"`
+}
+
+type badRequest struct {
+	Type            string           `json:"@type"`
+	FieldViolations []FieldViolation `json:"fieldViolations"`
+}
+
+type errorInfo struct {
+	Type     string            `json:"@type"`
+	Reason   string            `json:"reason"`
+	Domain   string            `json:"domain"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	format := s.errorFormat(r)
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	var payload interface{}
+	if err := decoder.Decode(&payload); err != nil {
+		s.writeError(w, r, format, "Invalid JSON payload received. "+err.Error(), nil)
+		return
+	}
+
+	// JSON payloads name the fields, they're laid out as JSPB before being validated
+	v := s.newValidator()
+	if object, ok := payload.(map[string]interface{}); ok && isJSON(r.Header.Get("Content-Type")) {
+		v.jsonNames = true
+		values, violation := v.decode(s.request, object, "")
+		if violation != nil {
+			s.writeError(w, r, format, violation.Description, []FieldViolation{*violation})
+			return
+		}
+		payload = values
+	}
+
+	values, ok := payload.([]interface{})
+	if !ok {
+		if s.HideRequestType {
+			s.writeError(w, r, format, "Invalid JSON payload received. Expected an array.", nil)
+		} else {
+			s.writeError(w, r, format, "Request contains an invalid argument.", []FieldViolation{
+				{Description: fmt.Sprintf("Invalid value (type.googleapis.com/%s), %s", s.request.FullName(), formatValue(payload))},
+			})
+		}
+		return
+	}
+
+	v.message(s.request, values, "")
+	if len(v.violations) == 0 {
+		s.writeResponse(w, r.URL.Query().Get("alt"))
+		return
+	}
+	if s.MaxViolations > 0 && len(v.violations) > s.MaxViolations {
+		v.violations = v.violations[:s.MaxViolations]
+	}
+	s.writeError(w, r, format, "Request contains an invalid argument.", v.violations)
+}
+
+// isJSON tells whether the Content-Type is plain JSON, as opposed to JSPB (application/json+protobuf)
+func isJSON(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return strings.TrimSpace(mediaType) == "application/json"
+}
+
+// Validate returns the violations of a decoded JSPB payload for the request message. Numbers have to be decoded as
+// json.Number.
+func (s *Server) Validate(payload []interface{}) []FieldViolation {
+	v := s.newValidator()
+	v.message(s.request, payload, "")
+	return v.violations
+}
+
+func (s *Server) newValidator() *validator {
+	v := &validator{positional: s.PositionalPaths, untyped: s.UntypedViolations, extensions: s.extensions}
+	for field, message := range s.SerializedMessages {
+		desc, err := s.files.FindDescriptorByName(protoreflect.FullName(message))
+		if err != nil {
+			continue
+		}
+		if md, ok := desc.(protoreflect.MessageDescriptor); ok {
+			if v.serialized == nil {
+				v.serialized = make(map[protoreflect.FullName]protoreflect.MessageDescriptor)
+			}
+			v.serialized[protoreflect.FullName(field)] = md
+		}
+	}
+	return v
+}
+
+func (s *Server) writeResponse(w http.ResponseWriter, alt string) {
+	var b []byte
+	var err error
+	switch alt {
+	case "proto":
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		if s.Response != nil {
+			b, err = proto.Marshal(s.Response)
+		}
+	case "protojson":
+		w.Header().Set("Content-Type", "application/json+protobuf; charset=UTF-8")
+		b = []byte("[]")
+		if s.Response != nil {
+			b, err = json.Marshal(marshalJSPB(s.Response.ProtoReflect()))
+		}
+	default:
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		b = []byte("{}")
+		if s.Response != nil {
+			b, err = protojson.Marshal(s.Response)
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(b)
+}
+
+// errorFormat returns how errors are encoded for the request: proto, protojson (JSPB) or json
+func (s *Server) errorFormat(r *http.Request) string {
+	if s.JSPBErrors {
+		return "protojson"
+	}
+	switch alt := r.URL.Query().Get("alt"); alt {
+	case "proto", "protojson":
+		return alt
+	case "json+protobuf":
+		return "protojson"
+	}
+	return "json"
+}
+
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, format string, message string, violations []FieldViolation) {
+	var metadata map[string]string
+	if s.Method != "" {
+		metadata = map[string]string{"method": s.Method, "service": r.Host}
+	}
+
+	switch format {
+	case "proto":
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(marshalStatus(message, violations, metadata))
+		return
+	case "protojson":
+		b, _ := json.Marshal(marshalJSPBStatus(message, violations, metadata))
+		w.Header().Set("Content-Type", "application/json+protobuf; charset=UTF-8")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(b)
+		return
+	}
+
+	var resp errorResponse
+	resp.Error.Code = http.StatusBadRequest
+	resp.Error.Message = message
+	resp.Error.Status = "INVALID_ARGUMENT"
+	if len(violations) > 0 {
+		resp.Error.Details = append(resp.Error.Details, badRequest{Type: badRequestTypeURL, FieldViolations: violations})
+	}
+	if metadata != nil {
+		resp.Error.Details = append(resp.Error.Details, errorInfo{Type: errorInfoTypeURL, Reason: "INVALID_ARGUMENT", Domain: "googleapis.com", Metadata: metadata})
+	}
+
+	b, _ := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write(b)
+}
+
+// marshalStatus encodes a google.rpc.Status with a google.rpc.BadRequest and a google.rpc.ErrorInfo by hand, as it's
+// only a few fields:
+//
+//	google.rpc.Status     { int32 code = 1; string message = 2; repeated google.protobuf.Any details = 3; }
+//	google.protobuf.Any   { string type_url = 1; bytes value = 2; }
+//	google.rpc.BadRequest { repeated FieldViolation field_violations = 1; }
+//	FieldViolation        { string field = 1; string description = 2; }
+//	google.rpc.ErrorInfo  { string reason = 1; string domain = 2; map<string, string> metadata = 3; }
+func marshalStatus(message string, violations []FieldViolation, metadata map[string]string) []byte {
+	var b []byte
+	// INVALID_ARGUMENT
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, 3)
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	b = protowire.AppendString(b, message)
+
+	if len(violations) > 0 {
+		var br []byte
+		for _, violation := range violations {
+			var fv []byte
+			fv = protowire.AppendTag(fv, 1, protowire.BytesType)
+			fv = protowire.AppendString(fv, violation.Field)
+			fv = protowire.AppendTag(fv, 2, protowire.BytesType)
+			fv = protowire.AppendString(fv, violation.Description)
+
+			br = protowire.AppendTag(br, 1, protowire.BytesType)
+			br = protowire.AppendBytes(br, fv)
+		}
+		b = appendAny(b, badRequestTypeURL, br)
+	}
+
+	if metadata != nil {
+		var ei []byte
+		ei = protowire.AppendTag(ei, 1, protowire.BytesType)
+		ei = protowire.AppendString(ei, "INVALID_ARGUMENT")
+		ei = protowire.AppendTag(ei, 2, protowire.BytesType)
+		ei = protowire.AppendString(ei, "googleapis.com")
+
+		// map entries are messages with the key as field 1 and the value as field 2, in a fixed order here
+		keys := make([]string, 0, len(metadata))
+		for k := range metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			var entry []byte
+			entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+			entry = protowire.AppendString(entry, k)
+			entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+			entry = protowire.AppendString(entry, metadata[k])
+
+			ei = protowire.AppendTag(ei, 3, protowire.BytesType)
+			ei = protowire.AppendBytes(ei, entry)
+		}
+		b = appendAny(b, errorInfoTypeURL, ei)
+	}
+
+	return b
+}
+
+// marshalJSPBStatus lays out the same google.rpc.Status as marshalStatus as JSPB, where position n-1 holds field n.
+// A google.protobuf.Any is the type url followed by the fields of the packed message, map entries are [key, value]
+// pairs and empty strings are null:
+//
+//	[3, "message", [["type.googleapis.com/google.rpc.BadRequest", [["field", "description"], ...]],
+//	                ["type.googleapis.com/google.rpc.ErrorInfo", "INVALID_ARGUMENT", "googleapis.com", [["method", "..."], ...]]]]
+func marshalJSPBStatus(message string, violations []FieldViolation, metadata map[string]string) []interface{} {
+	var details []interface{}
+	if len(violations) > 0 {
+		entries := make([]interface{}, 0, len(violations))
+		for _, violation := range violations {
+			entries = append(entries, []interface{}{jspbString(violation.Field), jspbString(violation.Description)})
+		}
+		details = append(details, []interface{}{badRequestTypeURL, entries})
+	}
+
+	if metadata != nil {
+		keys := make([]string, 0, len(metadata))
+		for k := range metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		pairs := make([]interface{}, 0, len(keys))
+		for _, k := range keys {
+			pairs = append(pairs, []interface{}{k, metadata[k]})
+		}
+		details = append(details, []interface{}{errorInfoTypeURL, "INVALID_ARGUMENT", "googleapis.com", pairs})
+	}
+
+	status := []interface{}{3, message}
+	if len(details) > 0 {
+		status = append(status, details)
+	}
+	return status
+}
+
+// jspbString returns nil for an empty string, which JSPB leaves unset
+func jspbString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// appendAny appends a detail of the status, packed in a google.protobuf.Any
+func appendAny(b []byte, typeURL string, value []byte) []byte {
+	var detail []byte
+	detail = protowire.AppendTag(detail, 1, protowire.BytesType)
+	detail = protowire.AppendString(detail, typeURL)
+	detail = protowire.AppendTag(detail, 2, protowire.BytesType)
+	detail = protowire.AppendBytes(detail, value)
+
+	b = protowire.AppendTag(b, 3, protowire.BytesType)
+	return protowire.AppendBytes(b, detail)
+}
+
+// validator collects the violations of a payload, in the order a frontend reports them: field by field, depth first
+type validator struct {
+	violations []FieldViolation
+	// positional reports fields by number in the paths, and jsonNames by JSON name (for JSON payloads)
+	positional bool
+	jsonNames  bool
+	untyped    bool
+	extensions map[protoreflect.FullName]map[protoreflect.FieldNumber]protoreflect.FieldDescriptor
+	// the messages held by bytes fields, see Server.SerializedMessages
+	serialized map[protoreflect.FullName]protoreflect.MessageDescriptor
+}
+
+// fieldPath returns the path of a field of the message at path
+func (v *validator) fieldPath(path string, fd protoreflect.FieldDescriptor) string {
+	name := string(fd.Name())
+	switch {
+	case v.positional:
+		name = strconv.Itoa(int(fd.Number()))
+	case fd.IsExtension():
+		// extensions are named by full name, like in the text format
+		name = "[" + string(fd.FullName()) + "]"
+	case v.jsonNames:
+		name = fd.JSONName()
+	}
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// decode lays a JSON object out as the JSPB payload of the message. Fields are looked up by JSON name and by name,
+// and the first unknown one fails the whole payload, like the JSON parser of a frontend.
+func (v *validator) decode(md protoreflect.MessageDescriptor, object map[string]interface{}, path string) ([]interface{}, *FieldViolation) {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var values []interface{}
+	for _, key := range keys {
+		fd := md.Fields().ByJSONName(key)
+		if fd == nil {
+			fd = md.Fields().ByName(protoreflect.Name(key))
+		}
+		if fd == nil {
+			description := fmt.Sprintf("Invalid JSON payload received. Unknown name %q: Cannot find field.", key)
+			if path != "" {
+				description = fmt.Sprintf("Invalid JSON payload received. Unknown name %q at '%s': Cannot find field.", key, path)
+			}
+			return nil, &FieldViolation{Field: path, Description: description}
+		}
+
+		value, violation := v.decodeValue(fd, object[key], v.fieldPath(path, fd))
+		if violation != nil {
+			return nil, violation
+		}
+		for len(values) < int(fd.Number()) {
+			values = append(values, nil)
+		}
+		values[fd.Number()-1] = value
+	}
+	return values, nil
+}
+
+// decodeValue lays out the value of a field: messages are nested payloads, and maps are lists of [key, value] entries.
+// Anything else is left for the validator to reject.
+func (v *validator) decodeValue(fd protoreflect.FieldDescriptor, value interface{}, path string) (interface{}, *FieldViolation) {
+	if fd.IsMap() {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return value, nil
+		}
+		keys := make([]string, 0, len(object))
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		entries := make([]interface{}, 0, len(keys))
+		for _, key := range keys {
+			entry, violation := v.decodeValue(fd.MapValue(), object[key], path)
+			if violation != nil {
+				return nil, violation
+			}
+			entries = append(entries, []interface{}{key, entry})
+		}
+		return entries, nil
+	}
+
+	if fd.Kind() != protoreflect.MessageKind && fd.Kind() != protoreflect.GroupKind {
+		return value, nil
+	}
+	if list, ok := value.([]interface{}); ok && fd.IsList() {
+		elems := make([]interface{}, len(list))
+		for i, elem := range list {
+			decoded, violation := v.decodeValue(fd, elem, fmt.Sprintf("%s[%d]", path, i))
+			if violation != nil {
+				return nil, violation
+			}
+			elems[i] = decoded
+		}
+		return elems, nil
+	}
+	if object, ok := value.(map[string]interface{}); ok {
+		return v.decode(fd.Message(), object, path)
+	}
+	return value, nil
+}
+
+func (v *validator) add(field string, description string) {
+	v.violations = append(v.violations, FieldViolation{Field: field, Description: description})
+}
+
+// message validates the fields of a message, where position n-1 holds field n. Unknown field numbers are ignored.
+func (v *validator) message(md protoreflect.MessageDescriptor, values []interface{}, path string) {
+	// the oneofs with a member set already
+	oneofs := make(map[protoreflect.FullName]bool)
+
+	for i, value := range values {
+		if value == nil {
+			continue
+		}
+		number := protoreflect.FieldNumber(i + 1)
+		fd := md.Fields().ByNumber(number)
+		if fd == nil {
+			fd = v.extensions[md.FullName()][number]
+		}
+		if fd == nil {
+			continue
+		}
+
+		fieldPath := v.fieldPath(path, fd)
+		if !v.field(fd, value, fieldPath) {
+			continue
+		}
+
+		if oneof := fd.ContainingOneof(); oneof != nil && !oneof.IsSynthetic() {
+			// the oneof is named, not the member that was set
+			if oneofs[oneof.FullName()] {
+				v.add(fieldPath, fmt.Sprintf("oneof field '%s' is already set. Cannot set '%s'", oneof.Name(), fd.Name()))
+				continue
+			}
+			oneofs[oneof.FullName()] = true
+		}
+	}
+
+	if path != "" {
+		v.required(md, values, path)
+	}
+}
+
+// required reports the required fields that aren't set, under the path of the message
+func (v *validator) required(md protoreflect.MessageDescriptor, values []interface{}, path string) {
+	for i := 0; i < md.Fields().Len(); i++ {
+		fd := md.Fields().Get(i)
+		if fd.Cardinality() != protoreflect.Required {
+			continue
+		}
+		if int(fd.Number()) <= len(values) && values[fd.Number()-1] != nil {
+			continue
+		}
+		v.add(path, fmt.Sprintf("Missing required field %s at '%s'", fd.Name(), path))
+	}
+}
+
+// field validates the value of a field, and returns whether it was valid. A list field accepts a single value as well,
+// which is validated like an element.
+func (v *validator) field(fd protoreflect.FieldDescriptor, value interface{}, path string) bool {
+	list, ok := value.([]interface{})
+	if !fd.IsList() && !fd.IsMap() || !ok {
+		return v.single(fd, value, path)
+	}
+
+	valid := true
+	for i, elem := range list {
+		if elem == nil {
+			continue
+		}
+		if !v.single(fd, elem, fmt.Sprintf("%s[%d]", path, i)) {
+			valid = false
+		}
+	}
+	return valid
+}
+
+// single validates a single value (a field, or an element of a list)
+func (v *validator) single(fd protoreflect.FieldDescriptor, value interface{}, path string) bool {
+	if fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind {
+		values, ok := value.([]interface{})
+		if !ok {
+			// the type of a group is named after the field, so it isn't reported
+			if fd.Kind() == protoreflect.GroupKind {
+				v.add(path, fmt.Sprintf("Invalid value at '%s' (TYPE_GROUP), %s", path, formatValue(value)))
+			} else {
+				v.add(path, fmt.Sprintf("Invalid value at '%s' (type.googleapis.com/%s), %s", path, fd.Message().FullName(), formatValue(value)))
+			}
+			// the sub-message is treated as empty, so its required fields are missing
+			v.required(fd.Message(), nil, path)
+			return false
+		}
+		before := len(v.violations)
+		v.message(fd.Message(), values, path)
+		return len(v.violations) == before
+	}
+
+	if _, ok := value.([]interface{}); ok {
+		if fd.IsList() {
+			v.add(path, "Invalid value (), List is not message or group type.")
+		} else {
+			v.add(path, "Invalid value (), Unexpected list for single non-message field.")
+		}
+		return false
+	}
+
+	if fd.Kind() == protoreflect.EnumKind {
+		if validEnum(fd.Enum(), value) {
+			return true
+		}
+		v.add(path, fmt.Sprintf("Invalid value at '%s' (type.googleapis.com/%s), %s", path, fd.Enum().FullName(), formatValue(value)))
+		return false
+	}
+
+	if fd.Kind() == protoreflect.BytesKind {
+		s, ok := value.(string)
+		if !ok {
+			v.add(path, fmt.Sprintf("Invalid value at '%s' (TYPE_BYTES), %s", path, formatValue(value)))
+			return false
+		}
+		if !validBase64(s) {
+			v.add(path, fmt.Sprintf("Invalid value at '%s' (TYPE_BYTES), Base64 decoding failed for %s", path, formatValue(value)))
+			return false
+		}
+		if md, ok := v.serialized[fd.FullName()]; ok && !validSerialized(md, s) {
+			// the value comes first, like in the other violations of a value
+			v.add(path, fmt.Sprintf("Invalid value at '%s' (TYPE_BYTES), %s is not a serialized type.googleapis.com/%s", path, formatValue(value), md.FullName()))
+			return false
+		}
+		return true
+	}
+
+	if validScalar(fd.Kind(), value) {
+		return true
+	}
+	if v.untyped {
+		v.add(path, fmt.Sprintf("Invalid value at '%s', %s", path, formatValue(value)))
+		return false
+	}
+	v.add(path, fmt.Sprintf("Invalid value at '%s' (%s), %s", path, descriptorpb.FieldDescriptorProto_Type(fd.Kind()), formatValue(value)))
+	return false
+}
+
+// validEnum accepts value names, and numbers. Closed (proto2) enums only accept the numbers of their values.
+func validEnum(ed protoreflect.EnumDescriptor, value interface{}) bool {
+	switch value := value.(type) {
+	case string:
+		return ed.Values().ByName(protoreflect.Name(value)) != nil
+	case json.Number:
+		n, err := strconv.ParseInt(string(value), 10, 32)
+		if err != nil {
+			return false
+		}
+		return !ed.IsClosed() || ed.Values().ByNumber(protoreflect.EnumNumber(n)) != nil
+	}
+	return false
+}
+
+func validBase64(s string) bool {
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		if _, err := encoding.DecodeString(s); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// validSerialized tells whether the base64 of s is a serialized md
+func validSerialized(md protoreflect.MessageDescriptor, s string) bool {
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		if b, err := encoding.DecodeString(s); err == nil {
+			return proto.Unmarshal(b, dynamicpb.NewMessage(md)) == nil
+		}
+	}
+	return false
+}
+
+// validScalar checks the value against the range of the type. Numbers are accepted as strings as well, like in
+// protojson, and floating point fields accept "NaN" and "Infinity".
+func validScalar(kind protoreflect.Kind, value interface{}) bool {
+	switch kind {
+	case protoreflect.StringKind:
+		_, ok := value.(string)
+		return ok
+	case protoreflect.BoolKind:
+		_, ok := value.(bool)
+		return ok
+	}
+
+	var s string
+	switch value := value.(type) {
+	case json.Number:
+		s = string(value)
+	case string:
+		s = value
+	default:
+		return false
+	}
+
+	var err error
+	switch kind {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		_, err = strconv.ParseInt(s, 10, 32)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		_, err = strconv.ParseUint(s, 10, 32)
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		_, err = strconv.ParseInt(s, 10, 64)
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		_, err = strconv.ParseUint(s, 10, 64)
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		if s == "NaN" || s == "Infinity" || s == "-Infinity" {
+			return true
+		}
+		var f float64
+		f, err = strconv.ParseFloat(s, 64)
+		if err == nil && kind == protoreflect.FloatKind && math.Abs(f) > math.MaxFloat32 {
+			return false
+		}
+	default:
+		return false
+	}
+	return err == nil
+}
+
+// formatValue formats the value the way it appears in a violation, strings are quoted
+func formatValue(value interface{}) string {
+	switch value := value.(type) {
+	case json.Number:
+		return string(value)
+	case string:
+		return strconv.Quote(value)
+	case bool:
+		return strconv.FormatBool(value)
+	}
+	b, _ := json.Marshal(value)
+	return strings.TrimSpace(string(b))
+}
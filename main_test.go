@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"req2proto/gapitest"
+	"req2proto/probe"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestWriteOutputDescriptorSet(t *testing.T) {
+	const requestType = "google.internal.test.v1.CreateItemRequest"
+
+	files, err := probe.LoadDescriptorPath("probe/testdata/schema")
+	if err != nil {
+		t.Fatalf("unable to load test schema: %v", err)
+	}
+	schema := &descriptorpb.FileDescriptorSet{}
+	for _, fd := range files {
+		schema.File = append(schema.File, protodesc.ToFileDescriptorProto(fd))
+	}
+	server, err := gapitest.NewServer(schema, requestType)
+	if err != nil {
+		t.Fatalf("unable to create fake server: %v", err)
+	}
+
+	p := probe.New(probe.WithTransport(probe.NewHandlerTransport(server)), probe.WithRetries(0), probe.WithSourceInfo())
+	descSet, err := p.Probe(context.Background(), "https://test-pa.googleapis.com/v1/items:create", requestType)
+	if err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	opts := &options{outputDir: filepath.Join(dir, "output"), descriptorSetOut: filepath.Join(dir, "set.binpb")}
+	writeOutput(opts, descSet, nil)
+
+	b, err := os.ReadFile(opts.descriptorSetOut)
+	if err != nil {
+		t.Fatalf("descriptor set wasn't written: %v", err)
+	}
+	written := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(b, written); err != nil {
+		t.Fatalf("descriptor set doesn't parse: %v", err)
+	}
+	if _, err := protodesc.NewFiles(written); err != nil {
+		t.Fatalf("descriptor set doesn't resolve: %v", err)
+	}
+
+	var comments int
+	for _, file := range written.File {
+		if file.GetName() != probe.FileName("google.internal.test.v1") {
+			continue
+		}
+		for _, loc := range file.GetSourceCodeInfo().GetLocation() {
+			if strings.Contains(loc.GetLeadingComments(), "discovered at index") {
+				comments++
+			}
+		}
+	}
+	if comments == 0 {
+		t.Errorf("want the discovery notes in the source_code_info of the written set")
+	}
+
+	if _, err := os.Stat(filepath.Join(opts.outputDir, probe.FileName("google.internal.test.v1"))); err != nil {
+		t.Errorf("proto file wasn't written: %v", err)
+	}
+}
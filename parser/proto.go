@@ -5,13 +5,18 @@ import (
 	"os"
 	"sort"
 	"strings"
+	"unicode"
 
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protodesc"
 	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/types/descriptorpb"
 )
 
+// google.api.http, an extension of google.protobuf.MethodOptions
+const httpRuleExtension = 72295728
+
 func GenerateProtoFile(fd protoreflect.FileDescriptor) string {
 	var sb strings.Builder
 
@@ -48,9 +53,114 @@ func GenerateProtoFile(fd protoreflect.FileDescriptor) string {
 		}
 	}
 
+	// Write extensions
+	if fd.Extensions().Len() > 0 {
+		if fd.Messages().Len() > 0 {
+			sb.WriteString("\n")
+		}
+		generateExtensions(&sb, fd.Extensions(), 0)
+	}
+
+	// Write services
+	for i := 0; i < fd.Services().Len(); i++ {
+		if i > 0 || fd.Messages().Len() > 0 || fd.Extensions().Len() > 0 {
+			sb.WriteString("\n")
+		}
+		generateService(&sb, fd.Services().Get(i))
+	}
+
 	return sb.String()
 }
 
+func generateService(sb *strings.Builder, service protoreflect.ServiceDescriptor) {
+	sb.WriteString(fmt.Sprintf("service %s {\n", service.Name()))
+
+	for i := 0; i < service.Methods().Len(); i++ {
+		method := service.Methods().Get(i)
+		signature := fmt.Sprintf("rpc %s(%s) returns (%s)", method.Name(), getMessageTypeName(method.Input(), method.ParentFile()), getMessageTypeName(method.Output(), method.ParentFile()))
+
+		rule := httpRuleOption(method)
+		if len(rule) == 0 {
+			sb.WriteString(fmt.Sprintf("  %s;\n", signature))
+			continue
+		}
+
+		sb.WriteString(fmt.Sprintf("  %s {\n", signature))
+		sb.WriteString("    option (google.api.http) = {\n")
+		for _, line := range rule {
+			sb.WriteString(fmt.Sprintf("      %s\n", line))
+		}
+		sb.WriteString("    };\n")
+		sb.WriteString("  }\n")
+	}
+
+	sb.WriteString("}\n")
+}
+
+// httpRuleOption returns the fields of the google.api.http option of the method, one per line. The extension isn't
+// linked into this binary, so the option is decoded from its wire format:
+//
+//	google.api.HttpRule { oneof pattern { string get = 2; string put = 3; string post = 4; string delete = 5;
+//	                      string patch = 6; CustomHttpPattern custom = 8; } string body = 7; }
+//	CustomHttpPattern   { string kind = 1; string path = 2; }
+func httpRuleOption(method protoreflect.MethodDescriptor) []string {
+	b, err := proto.Marshal(method.Options())
+	if err != nil {
+		return nil
+	}
+
+	rule := findBytesField(b, httpRuleExtension)
+	if rule == nil {
+		return nil
+	}
+
+	var lines []string
+	for _, pattern := range []struct {
+		number protowire.Number
+		name   string
+	}{{2, "get"}, {3, "put"}, {4, "post"}, {5, "delete"}, {6, "patch"}} {
+		if path := findBytesField(rule, pattern.number); path != nil {
+			lines = append(lines, fmt.Sprintf("%s: %q", pattern.name, path))
+		}
+	}
+	if custom := findBytesField(rule, 8); custom != nil {
+		lines = append(lines, fmt.Sprintf("custom: { kind: %q path: %q }", findBytesField(custom, 1), findBytesField(custom, 2)))
+	}
+	if body := findBytesField(rule, 7); body != nil {
+		lines = append(lines, fmt.Sprintf("body: %q", body))
+	}
+	return lines
+}
+
+// findBytesField returns the value of the last length-delimited field with the given number, or nil
+func findBytesField(b []byte, number protowire.Number) []byte {
+	var value []byte
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil
+		}
+		b = b[n:]
+
+		if num == number && typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil
+			}
+			value = v
+			b = b[n:]
+			continue
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return nil
+		}
+		b = b[n:]
+	}
+	return value
+}
+
 func generateEnum(sb *strings.Builder, enum protoreflect.EnumDescriptor, indent int) {
 	indentStr := strings.Repeat("  ", indent)
 	sb.WriteString(fmt.Sprintf("%senum %s {\n", indentStr, enum.Name()))
@@ -71,6 +181,13 @@ func generateMessage(sb *strings.Builder, msg protoreflect.MessageDescriptor, in
 
 	indentStr := strings.Repeat("  ", indent)
 	sb.WriteString(fmt.Sprintf("%smessage %s {\n", indentStr, msg.Name()))
+	generateMessageBody(sb, msg, indent)
+	sb.WriteString(fmt.Sprintf("%s}\n", indentStr))
+}
+
+// generateMessageBody generates what goes between the braces of a message, or of a group
+func generateMessageBody(sb *strings.Builder, msg protoreflect.MessageDescriptor, indent int) {
+	indentStr := strings.Repeat("  ", indent)
 
 	// Generate nested enums
 	for i := 0; i < msg.Enums().Len(); i++ {
@@ -79,9 +196,12 @@ func generateMessage(sb *strings.Builder, msg protoreflect.MessageDescriptor, in
 		sb.WriteString("\n")
 	}
 
-	// Generate nested messages
+	// Generate nested messages, groups are generated with their field
 	for i := 0; i < msg.Messages().Len(); i++ {
 		nestedMsg := msg.Messages().Get(i)
+		if nestedMsg.IsMapEntry() || isGroupMessage(nestedMsg) {
+			continue
+		}
 		generateMessage(sb, nestedMsg, indent+1)
 		sb.WriteString("\n")
 	}
@@ -95,25 +215,160 @@ func generateMessage(sb *strings.Builder, msg protoreflect.MessageDescriptor, in
 		return fields[i].Number() < fields[j].Number()
 	})
 
-	// Generate sorted fields
+	// Generate sorted fields, the fields of a oneof are generated together where the first one would be
+	generatedOneofs := make(map[protoreflect.FullName]bool)
 	for _, field := range fields {
-		fieldStr := generateField(field)
-		sb.WriteString(fmt.Sprintf("%s  %s;\n", indentStr, fieldStr))
+		oneof := field.ContainingOneof()
+		if oneof == nil || oneof.IsSynthetic() {
+			generateLeadingComments(sb, field, indent+1)
+			generateFieldLine(sb, field, indent+1)
+			continue
+		}
+
+		if generatedOneofs[oneof.FullName()] {
+			continue
+		}
+		generatedOneofs[oneof.FullName()] = true
+		generateOneof(sb, oneof, indent+1)
+	}
+
+	// Generate extension ranges, the end of a range is exclusive in a descriptor
+	ranges := msg.ExtensionRanges()
+	for i := 0; i < ranges.Len(); i++ {
+		r := ranges.Get(i)
+		start, end := r[0], r[1]-1
+		switch {
+		case start == end:
+			sb.WriteString(fmt.Sprintf("%s  extensions %d;\n", indentStr, start))
+		case end == protowire.MaxValidNumber:
+			sb.WriteString(fmt.Sprintf("%s  extensions %d to max;\n", indentStr, start))
+		default:
+			sb.WriteString(fmt.Sprintf("%s  extensions %d to %d;\n", indentStr, start, end))
+		}
+	}
+
+	// Generate extensions declared in the message
+	if msg.Extensions().Len() > 0 {
+		sb.WriteString("\n")
+		generateExtensions(sb, msg.Extensions(), indent+1)
+	}
+}
+
+// generateFieldLine generates a field, and the body of a group after it
+func generateFieldLine(sb *strings.Builder, field protoreflect.FieldDescriptor, indent int) {
+	indentStr := strings.Repeat("  ", indent)
+	if field.Kind() != protoreflect.GroupKind {
+		sb.WriteString(fmt.Sprintf("%s%s;%s\n", indentStr, generateField(field), trailingComment(field)))
+		return
+	}
+
+	sb.WriteString(fmt.Sprintf("%s%s {%s\n", indentStr, generateField(field), trailingComment(field)))
+	generateMessageBody(sb, field.Message(), indent)
+	sb.WriteString(fmt.Sprintf("%s}\n", indentStr))
+}
+
+// isGroupMessage tells whether the message is the type of a group, which is declared by the group field
+func isGroupMessage(msg protoreflect.MessageDescriptor) bool {
+	parent, ok := msg.Parent().(protoreflect.MessageDescriptor)
+	if !ok {
+		return false
+	}
+	for i := 0; i < parent.Fields().Len(); i++ {
+		field := parent.Fields().Get(i)
+		if field.Kind() == protoreflect.GroupKind && field.Message().FullName() == msg.FullName() {
+			return true
+		}
+	}
+	return false
+}
+
+// generateExtensions generates an extend block for each message extended, in the order the extensions are declared
+func generateExtensions(sb *strings.Builder, extensions protoreflect.ExtensionDescriptors, indent int) {
+	indentStr := strings.Repeat("  ", indent)
+
+	var extendees []protoreflect.FullName
+	byExtendee := make(map[protoreflect.FullName][]protoreflect.FieldDescriptor)
+	for i := 0; i < extensions.Len(); i++ {
+		ext := extensions.Get(i)
+		extendee := ext.ContainingMessage()
+		if _, ok := byExtendee[extendee.FullName()]; !ok {
+			extendees = append(extendees, extendee.FullName())
+		}
+		byExtendee[extendee.FullName()] = append(byExtendee[extendee.FullName()], ext)
+	}
+
+	for i, name := range extendees {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		fields := byExtendee[name]
+		sb.WriteString(fmt.Sprintf("%sextend %s {\n", indentStr, getMessageTypeName(fields[0].ContainingMessage(), fields[0].ParentFile())))
+		for _, field := range fields {
+			generateLeadingComments(sb, field, indent+1)
+			generateFieldLine(sb, field, indent+1)
+		}
+		sb.WriteString(fmt.Sprintf("%s}\n", indentStr))
+	}
+}
+
+func generateOneof(sb *strings.Builder, oneof protoreflect.OneofDescriptor, indent int) {
+	indentStr := strings.Repeat("  ", indent)
+	sb.WriteString(fmt.Sprintf("%soneof %s {\n", indentStr, oneof.Name()))
+
+	fields := make([]protoreflect.FieldDescriptor, oneof.Fields().Len())
+	for i := 0; i < oneof.Fields().Len(); i++ {
+		fields[i] = oneof.Fields().Get(i)
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Number() < fields[j].Number()
+	})
+
+	for _, field := range fields {
+		generateLeadingComments(sb, field, indent+1)
+		generateFieldLine(sb, field, indent+1)
 	}
 
 	sb.WriteString(fmt.Sprintf("%s}\n", indentStr))
 }
 
+func generateLeadingComments(sb *strings.Builder, desc protoreflect.Descriptor, indent int) {
+	comments := desc.ParentFile().SourceLocations().ByDescriptor(desc).LeadingComments
+	if comments == "" {
+		return
+	}
+
+	indentStr := strings.Repeat("  ", indent)
+	for _, line := range strings.Split(strings.TrimSuffix(comments, "\n"), "\n") {
+		sb.WriteString(fmt.Sprintf("%s//%s\n", indentStr, line))
+	}
+}
+
+// trailingComment returns the trailing comment of the descriptor to put at the end of its line, if any
+func trailingComment(desc protoreflect.Descriptor) string {
+	comments := strings.TrimSpace(desc.ParentFile().SourceLocations().ByDescriptor(desc).TrailingComments)
+	if comments == "" {
+		return ""
+	}
+	return " // " + strings.Join(strings.Split(comments, "\n"), " ")
+}
+
 func generateField(field protoreflect.FieldDescriptor) string {
 	var fieldStr string
 
-	// Handle label (optional, repeated)
+	// Handle label (optional, required, repeated)
 	if field.IsList() {
 		fieldStr += "repeated "
+	} else if field.Cardinality() == protoreflect.Required {
+		fieldStr += "required "
 	} else if field.HasOptionalKeyword() {
 		fieldStr += "optional "
 	}
 
+	// a group is named after its type, the body is generated after it
+	if field.Kind() == protoreflect.GroupKind {
+		return fieldStr + fmt.Sprintf("group %s = %d", field.Message().Name(), field.Number())
+	}
+
 	// Handle map fields
 	if field.IsMap() {
 		keyType := field.MapKey().Kind().String()
@@ -126,9 +381,32 @@ func generateField(field protoreflect.FieldDescriptor) string {
 
 	fieldStr += fmt.Sprintf(" %s = %d", field.Name(), field.Number())
 
+	// only a JSON name other than the default one has to be spelled out
+	if !field.IsExtension() && field.HasJSONName() && field.JSONName() != defaultJSONName(string(field.Name())) {
+		fieldStr += fmt.Sprintf(" [json_name = %q]", field.JSONName())
+	}
+
 	return fieldStr
 }
 
+// defaultJSONName returns the JSON name protoc gives a field, ex. primary_tag gives primaryTag
+func defaultJSONName(name string) string {
+	var sb strings.Builder
+	upper := false
+	for _, r := range name {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
 func getAppropriateTypeName(field protoreflect.FieldDescriptor, currentFile protoreflect.FileDescriptor) string {
 	if field.Kind() == protoreflect.MessageKind {
 		return getMessageTypeName(field.Message(), currentFile)
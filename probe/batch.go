@@ -0,0 +1,105 @@
+package probe
+
+import (
+	"context"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Endpoint is an endpoint of a batch. Options are applied on top of the options of the Prober, ex. WithMethod or
+// WithHeaders for this endpoint only.
+type Endpoint struct {
+	URL      string
+	RootType string
+	Options  []Option
+}
+
+// EndpointResult is the outcome of probing an endpoint of a batch
+type EndpointResult struct {
+	Endpoint Endpoint
+	// the request type, which is inferred if the endpoint left it out
+	RootType string
+	// every request sent, including retries
+	Requests int
+	// the requests that failed with a network error, 401/403, 429 or a transient 5xx
+	Failures int
+	Err      error
+}
+
+// ProbeBatch probes the endpoints one after another and merges the results into a single set. Every endpoint starts
+// from the packages discovered by the ones before it, so shared packages are generated once, and types that were
+// already discovered are linked instead of probed again. An endpoint that fails doesn't stop the batch, but what it
+// discovered is left out.
+func (p *Prober) ProbeBatch(ctx context.Context, endpoints []Endpoint) (*descriptorpb.FileDescriptorSet, []EndpointResult) {
+	merged := &descriptorpb.FileDescriptorSet{}
+	results := make([]EndpointResult, 0, len(endpoints))
+
+	for _, endpoint := range endpoints {
+		s := p.with(endpoint.Options).newSession(ctx)
+		s.seedBatchFiles(merged.File)
+
+		set, err := s.run(endpoint.URL, endpoint.RootType)
+		results = append(results, EndpointResult{
+			Endpoint: endpoint,
+			RootType: s.rootMessage,
+			Requests: int(s.client.requests.Load()),
+			Failures: int(s.client.failures.Load()),
+			Err:      err,
+		})
+		if err != nil {
+			s.logger.Error().Err(err).Str("url", endpoint.URL).Str("type", endpoint.RootType).Msg("unable to probe endpoint")
+			continue
+		}
+		merged = set
+	}
+
+	return merged, results
+}
+
+// with returns a copy of the Prober with opts applied on top of its options
+func (p *Prober) with(opts []Option) *Prober {
+	c := *p
+	c.headers = make(map[string]string, len(p.headers))
+	for k, v := range p.headers {
+		c.headers[k] = v
+	}
+	c.knownPaths = append([]string(nil), p.knownPaths...)
+	c.importPaths = append([]string(nil), p.importPaths...)
+
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return &c
+}
+
+// seedBatchFiles continues from the output of the previous endpoints of a batch. The discovered packages are restored
+// like from a checkpoint, and their types are registered as known so they aren't probed again.
+func (s *session) seedBatchFiles(files []*descriptorpb.FileDescriptorProto) {
+	for _, file := range files {
+		if file.GetName() == ServiceFileName(file.GetPackage()) {
+			s.serviceFDProtoMap[file.GetPackage()] = proto.Clone(file).(*descriptorpb.FileDescriptorProto)
+			continue
+		}
+		// imported files are part of the output, but aren't discovered packages
+		if file.GetName() != FileName(file.GetPackage()) {
+			continue
+		}
+
+		file = proto.Clone(file).(*descriptorpb.FileDescriptorProto)
+		packageName := file.GetPackage()
+
+		s.packageDependencyMap[packageName] = file.Dependency
+		file.Dependency = nil
+		s.restoreFieldDiscovery(file)
+		file.SourceCodeInfo = nil
+		s.packageFDProtoMap[packageName] = file
+
+		for _, enum := range file.EnumType {
+			s.knownTypes[packageName+"."+enum.GetName()] = knownType{Package: packageName, Type: descriptorpb.FieldDescriptorProto_TYPE_ENUM.Enum()}
+		}
+		for _, msg := range file.MessageType {
+			s.collectKnownTypes(msg, packageName, packageName)
+		}
+	}
+}
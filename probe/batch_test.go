@@ -0,0 +1,61 @@
+package probe
+
+import (
+	"context"
+	"net/http"
+	"req2proto/gapitest"
+	"testing"
+
+	"google.golang.org/protobuf/reflect/protodesc"
+)
+
+const updateRequest = "google.internal.test.v1.UpdateItemRequest"
+
+// newBatchProber serves CreateItemRequest and UpdateItemRequest on their own paths, and a 403 on /v1/forbidden
+func newBatchProber(t *testing.T) *Prober {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	for path, requestType := range map[string]string{"/v1/items:create": testRequest, "/v1/items:update": updateRequest} {
+		server, err := gapitest.NewServer(loadTestSchema(t), requestType)
+		if err != nil {
+			t.Fatalf("unable to create fake server: %v", err)
+		}
+		mux.Handle(path, server)
+	}
+	mux.HandleFunc("/v1/forbidden", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	})
+
+	return New(WithTransport(NewHandlerTransport(mux)), WithRetries(0))
+}
+
+func TestProbeBatchMergesEndpoints(t *testing.T) {
+	p := newBatchProber(t)
+	set, results := p.ProbeBatch(context.Background(), []Endpoint{
+		{URL: testURL, RootType: testRequest},
+		{URL: "https://test-pa.googleapis.com/v1/forbidden", RootType: "google.internal.test.v1.DeleteItemRequest"},
+		{URL: "https://test-pa.googleapis.com/v1/items:update", RootType: updateRequest, Options: []Option{WithMethod("PATCH")}},
+	})
+
+	if len(results) != 3 {
+		t.Fatalf("want 3 results, got %d", len(results))
+	}
+	if results[0].Err != nil || results[2].Err != nil {
+		t.Fatalf("endpoints failed: %v, %v", results[0].Err, results[2].Err)
+	}
+	if results[1].Err == nil || results[1].Failures != 1 {
+		t.Errorf("forbidden endpoint: want an error and 1 failure, got %v and %d", results[1].Err, results[1].Failures)
+	}
+
+	diffDescriptions(t, describeSchema(loadTestSchema(t).File, testRequest, updateRequest), describeSchema(set.File, testRequest, updateRequest))
+	if _, err := protodesc.NewFiles(set); err != nil {
+		t.Errorf("output doesn't resolve: %v", err)
+	}
+
+	// Item and Metadata were discovered by the first endpoint, so they aren't probed again
+	_, alone := p.ProbeBatch(context.Background(), []Endpoint{{URL: "https://test-pa.googleapis.com/v1/items:update", RootType: updateRequest}})
+	if results[2].Requests >= alone[0].Requests {
+		t.Errorf("shared types were probed again: %d requests in the batch, %d alone", results[2].Requests, alone[0].Requests)
+	}
+}
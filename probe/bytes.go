@@ -0,0 +1,102 @@
+package probe
+
+import (
+	"encoding/base64"
+	"regexp"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+var (
+	// type names in the description of a rejected serialized message, either as type URL or quoted, ex. "Failed to
+	// parse 'google.example.Foo'". Unquoted names are left alone, they may as well be a file or a Java class.
+	typeURLRe    = regexp.MustCompile(`type\.googleapis\.com/([\w.]+)`)
+	quotedTypeRe = regexp.MustCompile(`["'\x60]((?:[a-z][a-z0-9_]*\.)+[A-Z]\w*(?:\.[A-Z]\w*)*)["'\x60]`)
+)
+
+// detectBytesMessages sends every bytes field a valid serialized message and bytes that can't be parsed as one.
+// A field rejecting only the latter holds a serialized message, which is annotated on the field along with
+// its type if the server names it.
+func (s *session) detectBytesMessages() {
+	messages, _ := collectSchema(s.packageFiles())
+	indices := messageIndices(s.rootMessage, messages)
+
+	messageNames := make([]string, 0, len(indices))
+	for name := range indices {
+		messageNames = append(messageNames, name)
+	}
+	sort.Strings(messageNames)
+
+	// field 1 set to 1, and a varint that never ends
+	validMessage := base64.StdEncoding.EncodeToString(protowire.AppendVarint(protowire.AppendTag(nil, 1, protowire.VarintType), 1))
+	invalidMessage := base64.StdEncoding.EncodeToString([]byte{0xff, 0xff, 0xff, 0xff})
+
+	for _, name := range messageNames {
+		msg, ok := messages[name]
+		if !ok {
+			continue
+		}
+
+		for _, field := range msg.Field {
+			if field.GetType() != descriptorpb.FieldDescriptorProto_TYPE_BYTES || field.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+				continue
+			}
+
+			send := func(value string) (bool, string, error) {
+				fields := make([]interface{}, field.GetNumber())
+				fields[field.GetNumber()-1] = value
+				violations, err := s.probeAPI(genValuePayload(indices[name], fields))
+				if err != nil {
+					return false, "", err
+				}
+
+				for _, violation := range violations {
+					if isViolationOf(violation.Field, field) {
+						return false, violation.Description, nil
+					}
+				}
+				return true, "", nil
+			}
+
+			validAccepted, _, err := send(validMessage)
+			if err != nil {
+				s.logger.Error().Err(err).Str("message", name).Str("field_name", field.GetName()).Msg("error when probing bytes field")
+				continue
+			}
+			invalidAccepted, description, err := send(invalidMessage)
+			if err != nil {
+				s.logger.Error().Err(err).Str("message", name).Str("field_name", field.GetName()).Msg("error when probing bytes field")
+				continue
+			}
+
+			if !validAccepted || invalidAccepted {
+				continue
+			}
+
+			typeName := serializedTypeName(description)
+			if typeName != "" {
+				s.fieldAnnotationMap[field] = "serialized " + typeName
+			} else {
+				s.fieldAnnotationMap[field] = "serialized message of unknown type"
+			}
+			s.noteFieldDiscovery(field, "holds a serialized message, as bytes that aren't a valid message were rejected: %s", description)
+
+			if s.verbose {
+				s.logger.Debug().Str("message", name).Str("field_name", field.GetName()).Str("type", typeName).Msg("detected serialized message in bytes field")
+			}
+		}
+	}
+}
+
+// serializedTypeName returns the message type named in the description, if any
+func serializedTypeName(description string) string {
+	if matches := typeURLRe.FindStringSubmatch(description); matches != nil {
+		return matches[1]
+	}
+	if matches := quotedTypeRe.FindStringSubmatch(description); matches != nil {
+		return matches[1]
+	}
+	return ""
+}
@@ -0,0 +1,58 @@
+package probe
+
+import (
+	"context"
+	"req2proto/gapitest"
+	"testing"
+)
+
+func TestProbeBytesMessages(t *testing.T) {
+	// the trailing comment of CreateItemRequest.data, where the annotation ends up
+	annotation := func(serialized map[string]string) string {
+		server, err := gapitest.NewServer(loadTestSchema(t), testRequest)
+		if err != nil {
+			t.Fatalf("unable to create fake server: %v", err)
+		}
+		server.SerializedMessages = serialized
+
+		p := New(WithTransport(NewHandlerTransport(server)), WithRetries(0), WithBytesMessages())
+		set, err := p.Probe(context.Background(), testURL, testRequest)
+		if err != nil {
+			t.Fatalf("probe failed: %v", err)
+		}
+
+		for _, file := range set.File {
+			if file.GetName() != FileName("google.internal.test.v1") {
+				continue
+			}
+			for _, loc := range file.GetSourceCodeInfo().GetLocation() {
+				if len(loc.Path) == 4 && fieldAtPath(file, loc.Path).GetName() == "data" && file.MessageType[loc.Path[1]].GetName() == "CreateItemRequest" {
+					return loc.GetTrailingComments()
+				}
+			}
+		}
+		return ""
+	}
+
+	if got := annotation(map[string]string{testRequest + ".data": "google.internal.test.v1.Item.Tag"}); got != " serialized google.internal.test.v1.Item.Tag\n" {
+		t.Errorf("want the serialized type annotated, got %q", got)
+	}
+	// plain bytes take anything that decodes
+	if got := annotation(nil); got != "" {
+		t.Errorf("want plain bytes left alone, got %q", got)
+	}
+}
+
+func TestSerializedTypeName(t *testing.T) {
+	for description, want := range map[string]string{
+		"Invalid value at 'data' (TYPE_BYTES), \"/////w==\" is not a serialized type.googleapis.com/google.example.State": "google.example.State",
+		"Invalid value at 'data' (TYPE_BYTES), Failed to parse 'google.example.State'":                                    "google.example.State",
+		// a file name or a Java class isn't the type of the message
+		"Invalid value at 'data' (TYPE_BYTES), parse error in state.proto at com.google.Parser.parse(Parser.java:42)": "",
+		"Invalid value at 'data' (TYPE_BYTES), see google.example.State for the format":                               "",
+	} {
+		if got := serializedTypeName(description); got != want {
+			t.Errorf("%q: want %q, got %q", description, want, got)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package probe
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const (
+	checkpointDescriptorsFile = "descriptors.binpb"
+	checkpointQueueFile       = "queue.json"
+	checkpointEnumsFile       = "enums.json"
+	checkpointRootFile        = "root.txt"
+	// checkpointCurrentFile names the directory holding the latest checkpoint
+	checkpointCurrentFile = "current"
+)
+
+// checkpointEntry is a MsgChData that hasn't been applied yet. The descriptors are referenced by name,
+// as the pointers can't be serialized.
+type checkpointEntry struct {
+	Package               string   `json:"package"`
+	Message               string   `json:"message"`
+	Index                 []int    `json:"index"`
+	Element               bool     `json:"element,omitempty"`
+	ParentPackage         string   `json:"parent_package,omitempty"`
+	ParentMessage         string   `json:"parent_message,omitempty"`
+	RequiredFieldsToLabel []string `json:"required_fields_to_label,omitempty"`
+}
+
+// checkpointer periodically saves the discovered descriptors, along with every message still left to probe,
+// so that a run can be resumed with WithResume.
+type checkpointer struct {
+	dir      string
+	interval time.Duration
+	last     time.Time
+}
+
+// maybeSaveCheckpoint saves a checkpoint if the interval has passed since the last one. It must be called while
+// holding the turn, so that the descriptors and the queue are consistent with each other.
+func (s *session) maybeSaveCheckpoint(q *probeQueue) {
+	if s.checkpoint == nil || time.Since(s.checkpoint.last) < s.checkpoint.interval {
+		return
+	}
+
+	if err := s.saveCheckpoint(q); err != nil {
+		s.logger.Error().Err(err).Str("dir", s.checkpoint.dir).Msg("unable to save checkpoint")
+	}
+}
+
+func (s *session) saveCheckpoint(q *probeQueue) error {
+	c := s.checkpoint
+	c.last = time.Now()
+
+	descSet := &descriptorpb.FileDescriptorSet{}
+	for p, fdproto := range s.packageFDProtoMap {
+		file := proto.Clone(fdproto).(*descriptorpb.FileDescriptorProto)
+		file.Dependency = append(file.Dependency, s.packageDependencyMap[p]...)
+		file.SourceCodeInfo = s.buildSourceCodeInfo(fdproto, true)
+		descSet.File = append(descSet.File, file)
+	}
+
+	descBytes, err := proto.MarshalOptions{Deterministic: true}.Marshal(descSet)
+	if err != nil {
+		return err
+	}
+
+	var entries []checkpointEntry
+	for _, data := range q.outstanding() {
+		entry := checkpointEntry{
+			Package:               data.Package,
+			Message:               data.Message,
+			Index:                 data.Index,
+			Element:               data.Element,
+			RequiredFieldsToLabel: data.RequiredFieldsToLabel,
+		}
+		if data.ParentDescProto != nil {
+			entry.ParentPackage, entry.ParentMessage = s.findMessageName(data.ParentDescProto)
+		}
+		entries = append(entries, entry)
+	}
+
+	queueBytes, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	enumBytes, err := json.MarshalIndent(s.enumTargets, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := writeCheckpointFiles(c.dir, map[string][]byte{
+		checkpointDescriptorsFile: descBytes,
+		checkpointQueueFile:       queueBytes,
+		checkpointEnumsFile:       enumBytes,
+		checkpointRootFile:        []byte(s.rootMessage),
+	}); err != nil {
+		return err
+	}
+
+	s.logger.Info().Str("dir", c.dir).Int("queued", len(entries)).Msg("saved checkpoint")
+	return nil
+}
+
+// loadCheckpoint restores packageFDProtoMap and packageDependencyMap from a checkpoint, and returns the messages
+// that were still left to probe in the order they were queued, along with the request message (empty for older checkpoints).
+func (s *session) loadCheckpoint(dir string) ([]MsgChData, string, error) {
+	// older checkpoints keep the files in dir itself
+	if current, err := os.ReadFile(filepath.Join(dir, checkpointCurrentFile)); err == nil {
+		dir = filepath.Join(dir, strings.TrimSpace(string(current)))
+	}
+
+	descBytes, err := os.ReadFile(filepath.Join(dir, checkpointDescriptorsFile))
+	if err != nil {
+		return nil, "", err
+	}
+
+	descSet := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(descBytes, descSet); err != nil {
+		return nil, "", fmt.Errorf("unable to parse %s: %w", checkpointDescriptorsFile, err)
+	}
+
+	for _, file := range descSet.File {
+		s.packageDependencyMap[file.GetPackage()] = file.Dependency
+		file.Dependency = nil
+		s.restoreFieldDiscovery(file)
+		file.SourceCodeInfo = nil
+		s.packageFDProtoMap[file.GetPackage()] = file
+	}
+
+	queueBytes, err := os.ReadFile(filepath.Join(dir, checkpointQueueFile))
+	if err != nil {
+		return nil, "", err
+	}
+
+	var entries []checkpointEntry
+	if err := json.Unmarshal(queueBytes, &entries); err != nil {
+		return nil, "", fmt.Errorf("unable to parse %s: %w", checkpointQueueFile, err)
+	}
+
+	// older checkpoints don't have the enums yet
+	if enumBytes, err := os.ReadFile(filepath.Join(dir, checkpointEnumsFile)); err == nil {
+		if err := json.Unmarshal(enumBytes, &s.enumTargets); err != nil {
+			return nil, "", fmt.Errorf("unable to parse %s: %w", checkpointEnumsFile, err)
+		}
+	}
+
+	var queue []MsgChData
+	for _, entry := range entries {
+		fdproto, ok := s.packageFDProtoMap[entry.Package]
+		if !ok {
+			return nil, "", fmt.Errorf("queued message %s.%s refers to an unknown package", entry.Package, entry.Message)
+		}
+		descProto, _, err := getOrCreateMessageDescriptor(fdproto, entry.Message)
+		if err != nil {
+			return nil, "", err
+		}
+
+		data := MsgChData{
+			Package:               entry.Package,
+			Message:               entry.Message,
+			Index:                 entry.Index,
+			Element:               entry.Element,
+			DescProto:             descProto,
+			RequiredFieldsToLabel: entry.RequiredFieldsToLabel,
+		}
+
+		if entry.ParentMessage != "" {
+			parentFDProto, ok := s.packageFDProtoMap[entry.ParentPackage]
+			if !ok {
+				return nil, "", fmt.Errorf("queued message %s.%s refers to an unknown parent package", entry.Package, entry.Message)
+			}
+			data.ParentDescProto, _, err = getOrCreateMessageDescriptor(parentFDProto, entry.ParentMessage)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+
+		queue = append(queue, data)
+	}
+
+	root, _ := os.ReadFile(filepath.Join(dir, checkpointRootFile))
+	return queue, string(root), nil
+}
+
+// findMessageName returns the package and the (possibly nested) name of a message descriptor
+func (s *session) findMessageName(desc *descriptorpb.DescriptorProto) (string, string) {
+	for p, fdproto := range s.packageFDProtoMap {
+		if name, ok := findNestedMessageName(fdproto.MessageType, desc, ""); ok {
+			return p, name
+		}
+	}
+	return "", ""
+}
+
+func findNestedMessageName(messages []*descriptorpb.DescriptorProto, desc *descriptorpb.DescriptorProto, prefix string) (string, bool) {
+	for _, msg := range messages {
+		name := prefix + msg.GetName()
+		if msg == desc {
+			return name, true
+		}
+		if name, ok := findNestedMessageName(msg.NestedType, desc, name+"."); ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// writeCheckpointFiles writes the files of a checkpoint to a new directory in dir, and only then points dir to it, so
+// that the descriptors and the queue are always read from the same checkpoint, even after a crash in the middle of
+// a save. The directories of the previous checkpoints are removed afterwards.
+func writeCheckpointFiles(dir string, files map[string][]byte) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	generation, err := os.MkdirTemp(dir, "checkpoint-")
+	if err != nil {
+		return err
+	}
+
+	for name, fileContent := range files {
+		if err := os.WriteFile(filepath.Join(generation, name), fileContent, 0644); err != nil {
+			os.RemoveAll(generation)
+			return err
+		}
+	}
+	if err := writeFileAtomic([]byte(filepath.Base(generation)), filepath.Join(dir, checkpointCurrentFile)); err != nil {
+		os.RemoveAll(generation)
+		return err
+	}
+
+	previous, _ := filepath.Glob(filepath.Join(dir, "checkpoint-*"))
+	for _, path := range previous {
+		if path != generation {
+			os.RemoveAll(path)
+		}
+	}
+	return nil
+}
+
+// writeFileAtomic writes to a temporary file first, so that a crash never leaves a half-written checkpoint behind
+func writeFileAtomic(fileContent []byte, fileName string) error {
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(fileName+".tmp", fileContent, 0644); err != nil {
+		return err
+	}
+	return os.Rename(fileName+".tmp", fileName)
+}
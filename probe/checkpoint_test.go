@@ -0,0 +1,57 @@
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"req2proto/gapitest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	dir := t.TempDir()
+
+	// an interval of 0 saves at every turn
+	if _, err := probeTestSchema(t, context.Background(), WithCheckpoint(dir, 0)); err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+
+	current, err := os.ReadFile(filepath.Join(dir, checkpointCurrentFile))
+	if err != nil {
+		t.Fatalf("no current checkpoint: %v", err)
+	}
+	generations, _ := filepath.Glob(filepath.Join(dir, "checkpoint-*"))
+	if len(generations) != 1 || filepath.Base(generations[0]) != strings.TrimSpace(string(current)) {
+		t.Fatalf("want only the current checkpoint %s to be kept, got %v", current, generations)
+	}
+
+	// more queued messages than the workers could ever hold, all of them already probed
+	entries := make([]checkpointEntry, 1200)
+	for i := range entries {
+		entries[i] = checkpointEntry{Package: "google.internal.test.v1", Message: "CreateItemRequest", Index: []int{}}
+	}
+	queueBytes, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(generations[0], checkpointQueueFile), queueBytes, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := gapitest.NewServer(loadTestSchema(t), testRequest)
+	if err != nil {
+		t.Fatalf("unable to create fake server: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	p := New(WithTransport(NewHandlerTransport(server)), WithRetries(0), WithResume(dir))
+	set, err := p.Probe(ctx, testURL, "")
+	if err != nil {
+		t.Fatalf("resume failed: %v", err)
+	}
+	diffDescriptions(t, describeSchema(loadTestSchema(t).File, testRequest), describeSchema(set.File, testRequest))
+}
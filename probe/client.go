@@ -0,0 +1,201 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const maxBackoff = time.Minute
+
+// authError is returned for 401 and 403 responses, retrying won't help with those
+type authError struct {
+	status int
+	body   []byte
+}
+
+func (e *authError) Error() string {
+	return fmt.Sprintf("authentication failed with status %d (is the token expired or missing scopes?): %s", e.status, e.body)
+}
+
+// httpClient wraps a Transport with a QPS limit, a limit on requests in flight, and retries with exponential backoff
+// for network errors, quota errors (429) and transient server errors (500, 502, 503 and 504)
+type httpClient struct {
+	transport   Transport
+	inFlight    chan struct{}
+	maxRetries  int
+	baseBackoff time.Duration
+	logger      zerolog.Logger
+
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+
+	// every attempt is counted, failed ones (network errors, 401/403, 429 and transient 5xx) separately
+	requests atomic.Int64
+	failures atomic.Int64
+}
+
+// newHTTPClient creates a client, a qps or maxInFlight of 0 means unlimited. A nil transport uses NewFastHTTPTransport.
+func newHTTPClient(transport Transport, qps float64, maxInFlight int, maxRetries int, baseBackoff time.Duration, logger zerolog.Logger) *httpClient {
+	if transport == nil {
+		transport = NewFastHTTPTransport(nil)
+	}
+	c := &httpClient{
+		transport:   transport,
+		maxRetries:  maxRetries,
+		baseBackoff: baseBackoff,
+		logger:      logger,
+	}
+	if qps > 0 {
+		c.interval = time.Duration(float64(time.Second) / qps)
+	}
+	if maxInFlight > 0 {
+		c.inFlight = make(chan struct{}, maxInFlight)
+	}
+	return c
+}
+
+func (c *httpClient) do(ctx context.Context, req *Request) (*Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := c.waitRate(ctx); err != nil {
+			return nil, err
+		}
+
+		if c.inFlight != nil {
+			select {
+			case c.inFlight <- struct{}{}:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		resp, err := c.transport.Do(ctx, req)
+		if c.inFlight != nil {
+			<-c.inFlight
+		}
+		c.requests.Add(1)
+
+		var reason string
+		var retryAfter time.Duration
+		if errors.Is(err, ErrNotRecorded) {
+			// replaying it again won't make it recorded
+			c.failures.Add(1)
+			return nil, err
+		} else if err != nil {
+			reason = err.Error()
+		} else {
+			switch status := resp.StatusCode; {
+			case status == http.StatusUnauthorized || status == http.StatusForbidden:
+				c.failures.Add(1)
+				return nil, &authError{status: status, body: resp.Body}
+			case status == http.StatusTooManyRequests:
+				reason = "quota exceeded"
+				retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+			case isTransientStatus(status):
+				reason = fmt.Sprintf("server error %d", status)
+				retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+			default:
+				return resp, nil
+			}
+		}
+		c.failures.Add(1)
+
+		if attempt >= c.maxRetries {
+			if err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("giving up after %d retries: %s", attempt, reason)
+		}
+
+		wait := c.backoff(attempt)
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		c.logger.Warn().Str("reason", reason).Int("attempt", attempt+1).Dur("wait", wait).Msg("retrying request")
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// waitRate blocks until the next request is allowed by the QPS limit, or until ctx is done
+func (c *httpClient) waitRate(ctx context.Context) error {
+	if c.interval == 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	now := time.Now()
+	if c.next.Before(now) {
+		c.next = now
+	}
+	wait := c.next.Sub(now)
+	c.next = c.next.Add(c.interval)
+	c.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isTransientStatus tells whether a server error may go away on retry. 501 and 505 won't.
+func isTransientStatus(status int) bool {
+	switch status {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns an exponential backoff with equal jitter, half of it fixed and half random, so retries never come
+// back right away
+func (c *httpClient) backoff(attempt int) time.Duration {
+	d := c.baseBackoff << attempt
+	if d > maxBackoff || d <= 0 {
+		d = maxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// parseRetryAfter parses the Retry-After header, which is either a number of seconds or an HTTP date. The delay is
+// capped at maxBackoff, so a bogus header can't stall a worker for hours.
+func parseRetryAfter(value string) time.Duration {
+	var d time.Duration
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		// a huge number of seconds would overflow the duration
+		if seconds > int64(maxBackoff/time.Second) {
+			return maxBackoff
+		}
+		d = time.Duration(seconds) * time.Second
+	} else if t, err := http.ParseTime(value); err == nil {
+		d = time.Until(t)
+	}
+	if d > maxBackoff {
+		return maxBackoff
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
@@ -0,0 +1,110 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// statusTransport answers every request with the same status, and counts them
+type statusTransport struct {
+	status int
+	block  chan struct{}
+	count  atomic.Int64
+}
+
+func (t *statusTransport) Do(ctx context.Context, req *Request) (*Response, error) {
+	t.count.Add(1)
+	if t.block != nil {
+		<-t.block
+	}
+	return &Response{StatusCode: t.status, Header: http.Header{}}, nil
+}
+
+func TestHTTPClientRetries(t *testing.T) {
+	for status, wantAttempts := range map[int]int64{
+		http.StatusInternalServerError:     3,
+		http.StatusBadGateway:              3,
+		http.StatusServiceUnavailable:      3,
+		http.StatusGatewayTimeout:          3,
+		http.StatusTooManyRequests:         3,
+		http.StatusNotImplemented:          1,
+		http.StatusHTTPVersionNotSupported: 1,
+		http.StatusBadRequest:              1,
+	} {
+		transport := &statusTransport{status: status}
+		c := newHTTPClient(transport, 0, 0, 2, time.Millisecond, zerolog.Nop())
+		c.do(context.Background(), &Request{Method: "POST", URL: testURL})
+		if got := transport.count.Load(); got != wantAttempts {
+			t.Errorf("status %d: want %d attempts, got %d", status, wantAttempts, got)
+		}
+	}
+}
+
+func TestHTTPClientCancel(t *testing.T) {
+	t.Run("rate limit", func(t *testing.T) {
+		// the second request waits for an hour
+		c := newHTTPClient(&statusTransport{status: http.StatusOK}, 1.0/3600, 0, 0, time.Millisecond, zerolog.Nop())
+		if _, err := c.do(context.Background(), &Request{Method: "POST", URL: testURL}); err != nil {
+			t.Fatalf("first request failed: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		if _, err := c.do(ctx, &Request{Method: "POST", URL: testURL}); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("want the deadline error, got %v", err)
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Errorf("the request waited for the rate limit after its context was done")
+		}
+	})
+
+	t.Run("max in flight", func(t *testing.T) {
+		transport := &statusTransport{status: http.StatusOK, block: make(chan struct{})}
+		defer close(transport.block)
+		c := newHTTPClient(transport, 0, 1, 0, time.Millisecond, zerolog.Nop())
+		go c.do(context.Background(), &Request{Method: "POST", URL: testURL})
+		for transport.count.Load() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		done := make(chan error, 1)
+		go func() {
+			_, err := c.do(ctx, &Request{Method: "POST", URL: testURL})
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("want the deadline error, got %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("the request waited for a slot after its context was done")
+		}
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"":                    0,
+		"garbage":             0,
+		"-5":                  0,
+		"10":                  10 * time.Second,
+		"86400":               maxBackoff,
+		"9223372036854775807": maxBackoff,
+		time.Now().Add(24 * time.Hour).UTC().Format(http.TimeFormat): maxBackoff,
+		time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat):     0,
+	} {
+		if got := parseRetryAfter(value); got != want {
+			t.Errorf("%q: want %v, got %v", value, want, got)
+		}
+	}
+}
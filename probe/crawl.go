@@ -0,0 +1,667 @@
+package probe
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+var (
+	messageRe          = regexp.MustCompile(`^((?:[a-z0-9_]+\.)*[a-z0-9_]+)\.([A-Z][A-Za-z.0-9_]+)$`)
+	fieldDescRe        = regexp.MustCompile(`Invalid value at '(.+)' \((.*)\), (?:Base64 decoding failed for )?"?x?([^"]*)"?`)
+	requiredFieldRe    = regexp.MustCompile(`Missing required field (.+) at '([^']+)'`)
+	untypedFieldDescRe = regexp.MustCompile(`Invalid value at '(.+?)'[^,]*, (?:Base64 decoding failed for )?"?(x?)(\d+)"?`)
+)
+
+var typeMap = map[string]*descriptorpb.FieldDescriptorProto_Type{
+	"TYPE_STRING":   descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+	"TYPE_BOOL":     descriptorpb.FieldDescriptorProto_TYPE_BOOL.Enum(),
+	"TYPE_INT64":    descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum(),
+	"TYPE_UINT64":   descriptorpb.FieldDescriptorProto_TYPE_UINT64.Enum(),
+	"TYPE_INT32":    descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum(),
+	"TYPE_UINT32":   descriptorpb.FieldDescriptorProto_TYPE_UINT32.Enum(),
+	"TYPE_DOUBLE":   descriptorpb.FieldDescriptorProto_TYPE_DOUBLE.Enum(),
+	"TYPE_FLOAT":    descriptorpb.FieldDescriptorProto_TYPE_FLOAT.Enum(),
+	"TYPE_BYTES":    descriptorpb.FieldDescriptorProto_TYPE_BYTES.Enum(),
+	"TYPE_FIXED64":  descriptorpb.FieldDescriptorProto_TYPE_FIXED64.Enum(),
+	"TYPE_FIXED32":  descriptorpb.FieldDescriptorProto_TYPE_FIXED32.Enum(),
+	"TYPE_SINT64":   descriptorpb.FieldDescriptorProto_TYPE_SINT64.Enum(),
+	"TYPE_SINT32":   descriptorpb.FieldDescriptorProto_TYPE_SINT32.Enum(),
+	"TYPE_SFIXED64": descriptorpb.FieldDescriptorProto_TYPE_SFIXED64.Enum(),
+	"TYPE_SFIXED32": descriptorpb.FieldDescriptorProto_TYPE_SFIXED32.Enum(),
+}
+
+func (s *session) cleanupDuplicateFields(fdproto *descriptorpb.FileDescriptorProto) {
+	// Create maps for top-level enum names
+	enumNames := make(map[string]bool)
+
+	// Populate top-level enum names first
+	for _, enum := range fdproto.EnumType {
+		enumNames[*enum.Name] = true
+	}
+
+	// Check and remove conflicting top-level messages
+	var newMessageType []*descriptorpb.DescriptorProto
+	for _, msgType := range fdproto.MessageType {
+		if enumNames[*msgType.Name] {
+			if s.verbose {
+				s.logger.Debug().Str("message", *msgType.Name).Msg("Removed top-level message as it conflicts with an enum")
+			}
+		} else {
+			s.cleanupMessageType(msgType)
+			newMessageType = append(newMessageType, msgType)
+		}
+	}
+	fdproto.MessageType = newMessageType
+
+}
+
+func (s *session) cleanupMessageType(msgType *descriptorpb.DescriptorProto) {
+	// Create a map of enum names
+	enumNames := make(map[string]bool)
+
+	// Populate enum names first
+	for _, enum := range msgType.EnumType {
+		enumNames[*enum.Name] = true
+	}
+
+	// Check and remove conflicting nested types
+	var newNestedType []*descriptorpb.DescriptorProto
+	for _, nestedType := range msgType.NestedType {
+		if enumNames[*nestedType.Name] {
+			if s.verbose {
+				s.logger.Debug().Str("message", *nestedType.Name).Msg("Removed nested message as it conflicts with an enum")
+			}
+		} else {
+			newNestedType = append(newNestedType, nestedType)
+		}
+	}
+	msgType.NestedType = newNestedType
+
+	// Recursively clean up nested message types
+	for _, nestedType := range msgType.NestedType {
+		s.cleanupMessageType(nestedType)
+	}
+}
+
+func getOrCreateMessageDescriptor(fileDesc *descriptorpb.FileDescriptorProto, messageName string) (*descriptorpb.DescriptorProto, *descriptorpb.EnumDescriptorProto, error) {
+	parts := strings.Split(messageName, ".")
+	var currentMessage *descriptorpb.DescriptorProto
+	var currentMessages *[]*descriptorpb.DescriptorProto = &fileDesc.MessageType
+	var currentEnums *[]*descriptorpb.EnumDescriptorProto = &fileDesc.EnumType
+
+	for i, part := range parts {
+		if i == len(parts)-1 {
+			// Check if the last part is an enum
+			if enum := findEnum(currentEnums, part); enum != nil {
+				return nil, enum, nil
+			}
+		}
+
+		currentMessage = findOrCreateMessage(currentMessages, part)
+
+		if i < len(parts)-1 {
+			// If we're not at the last part, we need to go deeper
+			currentMessages = &currentMessage.NestedType
+			currentEnums = &currentMessage.EnumType
+		}
+	}
+
+	return currentMessage, nil, nil
+}
+
+func findOrCreateMessage(messages *[]*descriptorpb.DescriptorProto, name string) *descriptorpb.DescriptorProto {
+	for _, msg := range *messages {
+		if msg.GetName() == name {
+			return msg
+		}
+	}
+
+	// If the message doesn't exist, create it
+	newMessage := &descriptorpb.DescriptorProto{
+		Name: proto.String(name),
+	}
+	*messages = append(*messages, newMessage)
+	return newMessage
+}
+
+// addLinkedField adds a field referring to a message or enum that is already defined, so it doesn't need to be probed
+func (s *session) addLinkedField(msgChData MsgChData, fieldName string, number int, repeated bool, fieldType *descriptorpb.FieldDescriptorProto_Type, typeName string, description string, source string) {
+	label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+	for _, requiredField := range msgChData.RequiredFieldsToLabel {
+		if requiredField == fieldName {
+			label = descriptorpb.FieldDescriptorProto_LABEL_REQUIRED.Enum()
+			s.packageFDProtoMap[msgChData.Package].Syntax = proto.String("proto2")
+		}
+	}
+	if repeated {
+		label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	}
+
+	field := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(fieldName),
+		Number:   proto.Int32(int32(number)),
+		Label:    label,
+		Type:     fieldType,
+		TypeName: proto.String("." + typeName),
+		JsonName: proto.String(jsonName(fieldName)),
+	}
+	msgChData.DescProto.Field = append(msgChData.DescProto.Field, field)
+	s.noteFieldDiscovery(field, "discovered at index %v, %s: %s", msgChData.Index, source, description)
+	if repeated {
+		s.noteFieldDiscovery(field, "label set to repeated, as a list at index %v was accepted", msgChData.Index)
+	}
+}
+
+// addPackageDependency adds a file to the imports of a package, if it isn't there already
+func (s *session) addPackageDependency(packageName string, dependencyFileName string) {
+	for _, i := range s.packageDependencyMap[packageName] {
+		if i == dependencyFileName {
+			return
+		}
+	}
+	s.packageDependencyMap[packageName] = append(s.packageDependencyMap[packageName], dependencyFileName)
+}
+
+// packageFiles returns the file of every package, sorted by package name
+func (s *session) packageFiles() []*descriptorpb.FileDescriptorProto {
+	packageNames := make([]string, 0, len(s.packageFDProtoMap))
+	for p := range s.packageFDProtoMap {
+		packageNames = append(packageNames, p)
+	}
+	sort.Strings(packageNames)
+
+	files := make([]*descriptorpb.FileDescriptorProto, 0, len(packageNames))
+	for _, p := range packageNames {
+		files = append(files, s.packageFDProtoMap[p])
+	}
+	return files
+}
+
+func findEnum(enums *[]*descriptorpb.EnumDescriptorProto, name string) *descriptorpb.EnumDescriptorProto {
+	for _, enum := range *enums {
+		if enum.GetName() == name {
+			return enum
+		}
+	}
+	return nil
+}
+
+type MsgChData struct {
+	Package               string
+	Message               string
+	Index                 []int
+	DescProto             *descriptorpb.DescriptorProto
+	ParentDescProto       *descriptorpb.DescriptorProto
+	RequiredFieldsToLabel []string
+	// the last element of Index is the position in a list, the message is an element of a repeated field
+	Element bool
+
+	// order in which the message was queued, see probeQueue
+	seq int
+}
+
+// fieldNumber returns the number of the field of ParentDescProto the message is the value of
+func (m MsgChData) fieldNumber() int {
+	if m.Element {
+		return m.Index[len(m.Index)-2]
+	}
+	return m.Index[len(m.Index)-1]
+}
+
+// repeatedFieldNumbers returns the fields that took a list payload as a list of elements. Every value is wrapped in a
+// list, the element is the field number, which the violation of the element reports at <field>[0]. Other fields
+// reject the list (scalars) or take it as a nested message, and report at another path.
+func repeatedFieldNumbers(violations []FieldViolation) map[int]bool {
+	numbers := make(map[int]bool)
+	for _, violation := range violations {
+		z := strings.Split(violation.Field, ".")
+		if !strings.HasSuffix(z[len(z)-1], "[0]") {
+			continue
+		}
+
+		var value string
+		if matches := fieldDescRe.FindStringSubmatch(violation.Description); len(matches) == 4 {
+			value = matches[3]
+		} else if matches := untypedFieldDescRe.FindStringSubmatch(violation.Description); matches != nil {
+			value = matches[3]
+		}
+		if number, err := strconv.Atoi(value); err == nil {
+			numbers[number] = true
+		}
+	}
+	return numbers
+}
+
+// This function recieves fdProto and index of messages to probe further fields in
+func (s *session) probeNestedMessageWorker(q *probeQueue) {
+
+	for {
+		msgChData, ok := q.pop()
+		if !ok {
+			break
+		}
+
+		// probe for int violations
+		payload := genPayload(msgChData.Index, "int")
+		intViolations, err := s.probeAPI(payload)
+		if err != nil {
+			q.abort(fmt.Errorf("error when probing %s: %w", msgChData.Message, err))
+			return
+		}
+
+		// probe for str violations
+		payload = genPayload(msgChData.Index, "str")
+		violations, err := s.probeAPI(payload)
+		if err != nil {
+			q.abort(fmt.Errorf("error when probing %s: %w", msgChData.Message, err))
+			return
+		}
+
+		// add all violations together
+		violations = append(violations, intViolations...)
+
+		// probe for repeated fields, only those accept a list of values
+		repeatedFields := make(map[int]bool)
+		for _, dataType := range []string{"int-list", "str-list"} {
+			listViolations, err := s.probeAPI(genPayload(msgChData.Index, dataType))
+			if err != nil {
+				q.abort(fmt.Errorf("error when probing %s: %w", msgChData.Message, err))
+				return
+			}
+			for number := range repeatedFieldNumbers(listViolations) {
+				repeatedFields[number] = true
+			}
+		}
+
+		// everything below mutates the shared descriptors, so only one worker at a time may
+		// apply its violations, in the order the messages were queued
+		if !q.waitTurn(msgChData.seq) {
+			return
+		}
+		s.maybeSaveCheckpoint(q)
+
+		alreadyPresentFields := make(map[int]struct{})
+		for _, field := range msgChData.DescProto.Field {
+			alreadyPresentFields[int(*field.Number)] = struct{}{}
+		}
+
+		// first, we have to loop through violations and find if there's any required field errors. requiredFieldMap is a map of full field name of message and array of required fields
+		requiredFieldMap := make(map[string][]string, 300)
+		for _, i := range violations {
+			if strings.HasPrefix(i.Description, "Missing required field") {
+
+				x := requiredFieldRe.FindStringSubmatch(i.Description)
+				requiredFields, ok := requiredFieldMap[i.Field]
+				if !ok {
+					requiredFieldMap[i.Field] = []string{x[1]}
+				} else {
+					requiredFieldMap[i.Field] = append(requiredFields, x[1])
+				}
+			}
+		}
+
+		addedFields := make(map[string]struct{}, 100)
+		for _, i := range violations {
+
+			// enum
+			if i.Description == "Invalid value (), Unexpected list for single non-message field." || i.Description == "Invalid value (), List is not message or group type." {
+				// if enum, we find parent, then set it's field Type and TypeName. after that, we append an entry to EnumType.
+				x := strings.Split(msgChData.Message, ".")
+
+				lastIndex := msgChData.fieldNumber()
+
+				for _, i := range msgChData.ParentDescProto.Field {
+					if int(*i.Number) == lastIndex {
+						if s.verbose {
+							s.logger.Debug().Str("field_name", *i.Name).Str("package", msgChData.Package).Str("message", msgChData.Message).Msg("updated type to enum")
+						}
+						i.Type = descriptorpb.FieldDescriptorProto_TYPE_ENUM.Enum()
+						newTypeName := "." + msgChData.Package + "." + msgChData.Message
+						i.TypeName = &newTypeName
+						s.noteFieldDiscovery(i, "type set to enum, as a list at index %v was rejected for a non-message field", msgChData.Index)
+						s.addEnumTarget(msgChData.Package, msgChData.Message, *i.Name, *i.Number, msgChData.Index)
+					}
+				}
+
+				// If the parent is the FileDescriptorProto
+				if len(x) == 1 {
+					actualParentDesc := s.packageFDProtoMap[msgChData.Package]
+
+					exists := false
+					for _, i := range actualParentDesc.EnumType {
+						if *i.Name == msgChData.Message {
+							exists = true
+							break
+						}
+					}
+
+					if !exists {
+						actualParentDesc.EnumType = append(actualParentDesc.EnumType, &descriptorpb.EnumDescriptorProto{
+							Name: proto.String(msgChData.Message),
+							Value: []*descriptorpb.EnumValueDescriptorProto{
+								{Name: proto.String(convertToUnknownType(msgChData.Message)), Number: proto.Int32(0)},
+							},
+						})
+					}
+
+				} else {
+					actualParentDesc, _, err := getOrCreateMessageDescriptor(s.packageFDProtoMap[msgChData.Package], strings.Join(x[:len(x)-1], "."))
+					if err != nil {
+						q.abort(fmt.Errorf("error when probing %s: %w", msgChData.Message, err))
+						return
+					}
+
+					exists := false
+					for _, i := range actualParentDesc.EnumType {
+						if *i.Name == x[len(x)-1] {
+							exists = true
+							break
+						}
+					}
+
+					if !exists {
+						actualParentDesc.EnumType = append(actualParentDesc.EnumType, &descriptorpb.EnumDescriptorProto{
+							Name: proto.String(x[len(x)-1]),
+							Value: []*descriptorpb.EnumValueDescriptorProto{
+								{Name: proto.String(convertToUnknownType(x[len(x)-1])), Number: proto.Int32(0)},
+							},
+						})
+					}
+				}
+				break
+			}
+
+			// required field, we settled this before, so we can skip
+			if strings.HasPrefix(i.Description, "Missing required field") {
+				continue
+			}
+
+			// proto2 extensions
+			if x := extensionPathRe.FindStringSubmatch(i.Field); x != nil {
+				s.addExtension(q, msgChData, x[1], i.Description)
+				continue
+			}
+
+			z := strings.Split(i.Field, ".")
+			fieldName := z[len(z)-1]
+			matches := fieldDescRe.FindStringSubmatch(i.Description)
+			provisional := false
+			if len(matches) < 3 || (typeMap[matches[2]] == nil && matches[2] != "TYPE_GROUP" && !strings.Contains(matches[2], "type.googleapis.com/")) {
+				// the description doesn't name the type, ex. when it only says the value isn't a number
+				x := untypedFieldDescRe.FindStringSubmatch(i.Description)
+				if x == nil {
+					s.logger.Error().Str("description", i.Description).Str("message", msgChData.Message).Msg("unable to parse violation error description")
+					continue
+				}
+				if strings.Contains(i.Description, "Base64 decoding failed") {
+					matches = []string{x[0], x[1], "TYPE_BYTES", x[3]}
+				} else {
+					matches = []string{x[0], x[1], provisionalType(x[2] == "x"), x[3]}
+					provisional = true
+				}
+			}
+
+			// the number is the value sent at the field, a description without it can't be placed
+			number, err := strconv.Atoi(matches[3])
+			if err != nil || number <= 0 {
+				s.logger.Error().Str("description", i.Description).Str("message", msgChData.Message).Msg("unable to find the field number in violation error description")
+				continue
+			}
+
+			// the message is the value of a repeated field the list payloads of its parent didn't reveal, so the
+			// payload was taken as a list of elements. The field is found by number, as its name is unrelated to
+			// the name of the message, and the first element is probed instead.
+			if strings.HasSuffix(fieldName, "]") {
+				if msgChData.ParentDescProto == nil || msgChData.Element {
+					continue
+				}
+				if field := findFieldByNumber(msgChData.ParentDescProto, int32(msgChData.fieldNumber())); field != nil {
+					field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+					s.noteFieldDiscovery(field, "label set to repeated, as a list at index %v was accepted", msgChData.Index)
+				}
+
+				if s.verbose {
+					s.logger.Debug().Str("message", msgChData.Message).Str("parent_message", msgChData.ParentDescProto.GetName()).Str("package", msgChData.Package).Str("index", fmt.Sprint(msgChData.Index)).Msg("set field as repeated")
+				}
+
+				if s.maxDepth < 0 || !(len(msgChData.Index) == s.maxDepth) {
+					q.push(MsgChData{Package: msgChData.Package, Message: msgChData.Message, DescProto: msgChData.DescProto, ParentDescProto: msgChData.ParentDescProto, Index: append(msgChData.Index, 1), Element: true, RequiredFieldsToLabel: msgChData.RequiredFieldsToLabel})
+				}
+				break
+			}
+
+			// some frontends report fields by number, they're named after it until the names are recovered
+			if digitsRe.MatchString(fieldName) {
+				fieldName = unnamedFieldName(int32(number))
+			}
+
+			// proto2 group, the type isn't named as it's named after the field
+			if matches[2] == "TYPE_GROUP" {
+				if _, ok := alreadyPresentFields[number]; !ok {
+					label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+					for _, requiredField := range msgChData.RequiredFieldsToLabel {
+						if requiredField == fieldName {
+							label = descriptorpb.FieldDescriptorProto_LABEL_REQUIRED.Enum()
+						}
+					}
+					if repeatedFields[number] {
+						label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+					}
+
+					s.addGroupField(q, msgChData, fieldName, number, label, requiredFieldMap[i.Field], i.Description)
+					addedFields[fieldName] = struct{}{}
+					alreadyPresentFields[number] = struct{}{}
+				}
+				continue
+			}
+
+			// field is not a message
+			if strings.HasPrefix(matches[2], "TYPE_") {
+				_, ok := alreadyPresentFields[number]
+				if !ok {
+					label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+					for _, requiredField := range msgChData.RequiredFieldsToLabel {
+						if requiredField == fieldName {
+							label = descriptorpb.FieldDescriptorProto_LABEL_REQUIRED.Enum()
+							s.packageFDProtoMap[msgChData.Package].Syntax = proto.String("proto2")
+						}
+					}
+					if repeatedFields[number] {
+						label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+					}
+
+					addedFields[fieldName] = struct{}{}
+					field := &descriptorpb.FieldDescriptorProto{
+						Name:     proto.String(fieldName),
+						Number:   proto.Int32(int32(number)),
+						Label:    label,
+						Type:     typeMap[matches[2]],
+						JsonName: proto.String(jsonName(fieldName)),
+					}
+					msgChData.DescProto.Field = append(msgChData.DescProto.Field, field)
+					s.noteFieldDiscovery(field, "discovered at index %v: %s", msgChData.Index, i.Description)
+					if repeatedFields[number] {
+						s.noteFieldDiscovery(field, "label set to repeated, as a list at index %v was accepted", msgChData.Index)
+					}
+					if provisional {
+						s.provisionalFields[field] = true
+						s.noteFieldDiscovery(field, "type %s is a guess, as the description doesn't name the type", matches[2])
+					}
+					alreadyPresentFields[number] = struct{}{}
+				}
+			} else {
+				_, ok := alreadyPresentFields[number]
+				if !ok {
+					typeName := strings.Split(matches[2], "type.googleapis.com/")[1]
+
+					// types loaded with --known are linked by name instead of probed again
+					if known, ok := s.knownTypes[typeName]; ok {
+						if known.Package != msgChData.Package {
+							s.addPackageDependency(msgChData.Package, FileName(known.Package))
+						}
+						s.addLinkedField(msgChData, fieldName, number, repeatedFields[number], known.Type, typeName, i.Description, "known")
+						addedFields[fieldName] = struct{}{}
+						alreadyPresentFields[number] = struct{}{}
+						continue
+					}
+
+					// types that are already defined elsewhere (well-known types, --import-path) are imported instead of probed
+					if desc, ok := s.resolveExternalType(typeName); ok {
+						s.addPackageDependency(msgChData.Package, desc.ParentFile().Path())
+
+						fieldType := descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+						if _, isEnum := desc.(protoreflect.EnumDescriptor); isEnum {
+							fieldType = descriptorpb.FieldDescriptorProto_TYPE_ENUM.Enum()
+						}
+
+						s.addLinkedField(msgChData, fieldName, number, repeatedFields[number], fieldType, typeName, i.Description, "imported from "+desc.ParentFile().Path())
+						addedFields[fieldName] = struct{}{}
+						alreadyPresentFields[number] = struct{}{}
+						continue
+					}
+
+					z := strings.Split(matches[2], ".")
+					nestedMessageName := z[len(z)-1]
+
+					// getting the package and message name from the googleapi type
+					x := messageRe.FindStringSubmatch(strings.Split(matches[2], "type.googleapis.com/")[1])
+					var packageName, fullMessageName string
+					if x != nil {
+						packageName = x[1]
+						fullMessageName = x[2]
+					} else {
+						// if we can't find a package name, we just put it under google
+						packageName = "google"
+						fullMessageName = strings.Split(matches[2], "type.googleapis.com/")[1]
+						nestedMessageName = fullMessageName
+					}
+
+					var descProto *descriptorpb.DescriptorProto
+					fdproto, ok := s.packageFDProtoMap[packageName]
+					if !ok {
+						// we can't find a fdproto for that package, so we make a new one
+						s.packageFDProtoMap[packageName] = &descriptorpb.FileDescriptorProto{
+							Name:    proto.String(FileName(packageName)),
+							Syntax:  proto.String("proto3"),
+							Package: proto.String(packageName),
+							MessageType: []*descriptorpb.DescriptorProto{
+								{
+									Name: proto.String(nestedMessageName),
+								},
+							},
+						}
+
+						fdproto = s.packageFDProtoMap[packageName]
+						descProto = fdproto.MessageType[0]
+
+					} else {
+						// the fdproto for that package exists, so we find if the message exists in there, if it doesn't, create it
+						var enum *descriptorpb.EnumDescriptorProto
+						descProto, enum, err = getOrCreateMessageDescriptor(fdproto, fullMessageName)
+						if err != nil {
+							q.abort(fmt.Errorf("error when probing %s: %w", msgChData.Message, err))
+							return
+						}
+
+						// skip, as there's already an enum with this name
+						if enum != nil {
+							continue
+						}
+					}
+
+					// adding dependency of package if it's on another file
+					if packageName != msgChData.Package {
+						s.addPackageDependency(msgChData.Package, FileName(packageName))
+					}
+
+					// send the descProto to msgCh so that it will be probed next
+					if s.maxDepth < 0 || !(len(msgChData.Index) == s.maxDepth) {
+						newIndex := append(msgChData.Index, number)
+						// the first element of a list
+						if repeatedFields[number] {
+							newIndex = append(newIndex, 1)
+						}
+						requiredFields := requiredFieldMap[i.Field]
+						q.push(MsgChData{Package: packageName, Message: fullMessageName, DescProto: descProto, ParentDescProto: msgChData.DescProto, Index: newIndex, Element: repeatedFields[number], RequiredFieldsToLabel: requiredFields})
+					}
+
+					label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+					for _, requiredField := range msgChData.RequiredFieldsToLabel {
+						if requiredField == fieldName {
+							label = descriptorpb.FieldDescriptorProto_LABEL_REQUIRED.Enum()
+							s.packageFDProtoMap[packageName].Syntax = proto.String("proto2")
+						}
+					}
+					if repeatedFields[number] {
+						label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+					}
+
+					addedFields[fieldName] = struct{}{}
+					field := &descriptorpb.FieldDescriptorProto{
+						Name:     proto.String(fieldName),
+						Number:   proto.Int32(int32(number)),
+						Label:    label,
+						Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+						TypeName: proto.String("." + strings.Split(matches[2], "type.googleapis.com/")[1]),
+						JsonName: proto.String(jsonName(fieldName)),
+					}
+					msgChData.DescProto.Field = append(msgChData.DescProto.Field, field)
+					s.noteFieldDiscovery(field, "discovered at index %v: %s", msgChData.Index, i.Description)
+					if repeatedFields[number] {
+						s.noteFieldDiscovery(field, "label set to repeated, as a list at index %v was accepted", msgChData.Index)
+					}
+					alreadyPresentFields[number] = struct{}{}
+				}
+
+			}
+
+		}
+
+		q.finishTurn()
+
+		if s.verbose {
+			pending, inFlight := q.counts()
+			s.logger.Debug().Str("package", msgChData.Package).Str("message", msgChData.Message).Int("pending", pending).Int("in_flight", inFlight).Msg("probed message")
+		}
+	}
+
+}
+
+// setAltParameter sets the alt query parameter, which decides the encoding of the response. With "auto" the URL is left as is
+// and the response is parsed according to the Content-Type the server answers with.
+func setAltParameter(inputURL string, alt string) (string, error) {
+	switch alt {
+	case "auto":
+		return inputURL, nil
+	case "json", "proto":
+	default:
+		return "", fmt.Errorf("unknown response encoding %q (supported: json, proto, auto)", alt)
+	}
+
+	return withAltParameter(inputURL, alt)
+}
+
+// withAltParameter sets the alt query parameter, without checking the value
+func withAltParameter(inputURL string, alt string) (string, error) {
+	// Parse the URL
+	parsedURL, err := url.Parse(inputURL)
+	if err != nil {
+		return "", err
+	}
+
+	// Set the alt parameter, replacing it if it already exists
+	values := parsedURL.Query()
+	values.Set("alt", alt)
+
+	// Set the new query string
+	parsedURL.RawQuery = values.Encode()
+	return parsedURL.String(), nil
+}
@@ -0,0 +1,138 @@
+package probe
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func (s *session) noteFieldDiscovery(field *descriptorpb.FieldDescriptorProto, format string, args ...interface{}) {
+	s.fieldDiscoveryMap[field] = append(s.fieldDiscoveryMap[field], fmt.Sprintf(format, args...))
+}
+
+// buildSourceCodeInfo creates a location for every field with discovery notes (if includeNotes) or an annotation,
+// with the notes as leading comments and the annotation as trailing comment. It returns nil if there's no location.
+func (s *session) buildSourceCodeInfo(fdproto *descriptorpb.FileDescriptorProto, includeNotes bool) *descriptorpb.SourceCodeInfo {
+	info := &descriptorpb.SourceCodeInfo{}
+
+	// path of a message is [4, index] at the root, and [..., 3, index] for nested messages. Fields are [..., 2, index]
+	var addMessages func(messages []*descriptorpb.DescriptorProto, path []int32)
+	addMessages = func(messages []*descriptorpb.DescriptorProto, path []int32) {
+		for i, msg := range messages {
+			msgPath := append(append([]int32(nil), path...), int32(i))
+
+			for j, field := range msg.Field {
+				notes, hasNotes := s.fieldDiscoveryMap[field]
+				annotation, hasAnnotation := s.fieldAnnotationMap[field]
+				hasNotes = hasNotes && includeNotes
+				if !hasNotes && !hasAnnotation {
+					continue
+				}
+
+				loc := &descriptorpb.SourceCodeInfo_Location{
+					Path: append(append([]int32(nil), msgPath...), 2, int32(j)),
+					Span: []int32{0, 0, 0},
+				}
+				if hasNotes {
+					loc.LeadingComments = proto.String(" " + strings.Join(notes, "\n ") + "\n")
+				}
+				if hasAnnotation {
+					loc.TrailingComments = proto.String(" " + annotation + "\n")
+				}
+				info.Location = append(info.Location, loc)
+			}
+
+			addMessages(msg.NestedType, append(msgPath, 3))
+		}
+	}
+	addMessages(fdproto.MessageType, []int32{4})
+
+	if len(info.Location) == 0 {
+		return nil
+	}
+	return info
+}
+
+// restoreFieldDiscovery is the reverse of buildSourceCodeInfo, used when resuming from a checkpoint
+func (s *session) restoreFieldDiscovery(fdproto *descriptorpb.FileDescriptorProto) {
+	for _, loc := range fdproto.GetSourceCodeInfo().GetLocation() {
+		field := fieldAtPath(fdproto, loc.Path)
+		if field == nil {
+			continue
+		}
+		if loc.LeadingComments != nil {
+			for _, note := range strings.Split(strings.TrimSpace(loc.GetLeadingComments()), "\n") {
+				s.fieldDiscoveryMap[field] = append(s.fieldDiscoveryMap[field], strings.TrimSpace(note))
+			}
+		}
+		if loc.TrailingComments != nil {
+			s.fieldAnnotationMap[field] = strings.TrimSpace(loc.GetTrailingComments())
+		}
+	}
+}
+
+func fieldAtPath(fdproto *descriptorpb.FileDescriptorProto, path []int32) *descriptorpb.FieldDescriptorProto {
+	if len(path) < 4 || path[0] != 4 {
+		return nil
+	}
+
+	messages := fdproto.MessageType
+	for len(path) >= 2 {
+		if int(path[1]) >= len(messages) {
+			return nil
+		}
+		msg := messages[path[1]]
+		path = path[2:]
+
+		switch {
+		case len(path) == 2 && path[0] == 2 && int(path[1]) < len(msg.Field):
+			return msg.Field[path[1]]
+		case len(path) > 2 && path[0] == 3:
+			messages = msg.NestedType
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// sortFilesTopologically orders the files so that every file comes after its dependencies, as expected in a FileDescriptorSet.
+// Files without a dependency relation are sorted by name, so the order is stable between runs.
+func sortFilesTopologically(files []*descriptorpb.FileDescriptorProto) []*descriptorpb.FileDescriptorProto {
+	byName := make(map[string]*descriptorpb.FileDescriptorProto, len(files))
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		byName[file.GetName()] = file
+		names = append(names, file.GetName())
+	}
+	sort.Strings(names)
+
+	sorted := make([]*descriptorpb.FileDescriptorProto, 0, len(files))
+	visited := make(map[string]bool, len(files))
+
+	var visit func(name string)
+	visit = func(name string) {
+		file, ok := byName[name]
+		if !ok || visited[name] {
+			return
+		}
+		// marked before the dependencies are visited, so that an import cycle can't recurse forever
+		visited[name] = true
+
+		deps := append([]string(nil), file.Dependency...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			visit(dep)
+		}
+		sorted = append(sorted, file)
+	}
+
+	for _, name := range names {
+		visit(name)
+	}
+
+	return sorted
+}
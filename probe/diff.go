@@ -0,0 +1,203 @@
+package probe
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// SchemaChange is a single difference between two versions of a schema
+type SchemaChange struct {
+	Kind     string `json:"kind"`
+	Element  string `json:"element"`
+	Old      string `json:"old,omitempty"`
+	New      string `json:"new,omitempty"`
+	Breaking bool   `json:"breaking"`
+}
+
+// DiffSchemas compares messages and enums by full name, and fields by name or by number
+func DiffSchemas(oldFiles []*descriptorpb.FileDescriptorProto, newFiles []*descriptorpb.FileDescriptorProto) []SchemaChange {
+	oldMessages, oldEnums := collectSchema(oldFiles)
+	newMessages, newEnums := collectSchema(newFiles)
+
+	var changes []SchemaChange
+
+	for name, oldMsg := range oldMessages {
+		newMsg, ok := newMessages[name]
+		if !ok {
+			changes = append(changes, SchemaChange{Kind: "message_removed", Element: name, Breaking: true})
+			continue
+		}
+		changes = append(changes, diffFields(name, oldMsg, newMsg)...)
+	}
+	for name := range newMessages {
+		if _, ok := oldMessages[name]; !ok {
+			changes = append(changes, SchemaChange{Kind: "message_added", Element: name})
+		}
+	}
+
+	for name, oldEnum := range oldEnums {
+		newEnum, ok := newEnums[name]
+		if !ok {
+			changes = append(changes, SchemaChange{Kind: "enum_removed", Element: name, Breaking: true})
+			continue
+		}
+		changes = append(changes, diffEnumValues(name, oldEnum, newEnum)...)
+	}
+	for name := range newEnums {
+		if _, ok := oldEnums[name]; !ok {
+			changes = append(changes, SchemaChange{Kind: "enum_added", Element: name})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Element != changes[j].Element {
+			return changes[i].Element < changes[j].Element
+		}
+		return changes[i].Kind < changes[j].Kind
+	})
+	return changes
+}
+
+// diffFields pairs the fields by name first, and the fields left over by number, so a renamed field (ex. field_3 once
+// its name is recovered) is reported as such rather than removed and added again
+func diffFields(msgName string, oldMsg *descriptorpb.DescriptorProto, newMsg *descriptorpb.DescriptorProto) []SchemaChange {
+	var changes []SchemaChange
+
+	newByName := make(map[string]*descriptorpb.FieldDescriptorProto, len(newMsg.Field))
+	for _, field := range newMsg.Field {
+		newByName[field.GetName()] = field
+	}
+
+	matched := make(map[*descriptorpb.FieldDescriptorProto]*descriptorpb.FieldDescriptorProto, len(oldMsg.Field))
+	paired := make(map[*descriptorpb.FieldDescriptorProto]bool, len(newMsg.Field))
+	for _, oldField := range oldMsg.Field {
+		if newField, ok := newByName[oldField.GetName()]; ok {
+			matched[oldField] = newField
+			paired[newField] = true
+		}
+	}
+
+	newByNumber := make(map[int32]*descriptorpb.FieldDescriptorProto, len(newMsg.Field))
+	for _, field := range newMsg.Field {
+		if !paired[field] {
+			newByNumber[field.GetNumber()] = field
+		}
+	}
+	for _, oldField := range oldMsg.Field {
+		if _, ok := matched[oldField]; ok {
+			continue
+		}
+		if newField, ok := newByNumber[oldField.GetNumber()]; ok {
+			matched[oldField] = newField
+			paired[newField] = true
+			delete(newByNumber, oldField.GetNumber())
+		}
+	}
+
+	for _, oldField := range oldMsg.Field {
+		element := msgName + "." + oldField.GetName()
+
+		newField, ok := matched[oldField]
+		if !ok {
+			changes = append(changes, SchemaChange{Kind: "field_removed", Element: element, Old: describeField(oldField), Breaking: true})
+			continue
+		}
+
+		// the wire format only knows the number
+		if oldField.GetName() != newField.GetName() {
+			changes = append(changes, SchemaChange{Kind: "field_renamed", Element: element, Old: oldField.GetName(), New: newField.GetName()})
+		}
+		if oldField.GetNumber() != newField.GetNumber() {
+			changes = append(changes, SchemaChange{Kind: "field_number_changed", Element: element, Old: fmt.Sprint(oldField.GetNumber()), New: fmt.Sprint(newField.GetNumber()), Breaking: true})
+		}
+		if oldType, newType := fieldTypeName(oldField), fieldTypeName(newField); oldType != newType {
+			changes = append(changes, SchemaChange{Kind: "field_type_changed", Element: element, Old: oldType, New: newType, Breaking: true})
+		}
+		if oldField.GetLabel() != newField.GetLabel() {
+			changes = append(changes, SchemaChange{Kind: "field_label_changed", Element: element, Old: labelName(oldField), New: labelName(newField), Breaking: true})
+		}
+	}
+
+	for _, newField := range newMsg.Field {
+		if !paired[newField] {
+			changes = append(changes, SchemaChange{Kind: "field_added", Element: msgName + "." + newField.GetName(), New: describeField(newField)})
+		}
+	}
+
+	return changes
+}
+
+func diffEnumValues(enumName string, oldEnum *descriptorpb.EnumDescriptorProto, newEnum *descriptorpb.EnumDescriptorProto) []SchemaChange {
+	var changes []SchemaChange
+
+	newValues := make(map[string]int32, len(newEnum.Value))
+	for _, value := range newEnum.Value {
+		newValues[value.GetName()] = value.GetNumber()
+	}
+
+	oldValues := make(map[string]bool, len(oldEnum.Value))
+	for _, value := range oldEnum.Value {
+		oldValues[value.GetName()] = true
+		element := enumName + "." + value.GetName()
+
+		number, ok := newValues[value.GetName()]
+		if !ok {
+			changes = append(changes, SchemaChange{Kind: "enum_value_removed", Element: element, Old: fmt.Sprint(value.GetNumber()), Breaking: true})
+		} else if number != value.GetNumber() {
+			changes = append(changes, SchemaChange{Kind: "enum_value_changed", Element: element, Old: fmt.Sprint(value.GetNumber()), New: fmt.Sprint(number), Breaking: true})
+		}
+	}
+
+	for _, value := range newEnum.Value {
+		if !oldValues[value.GetName()] {
+			changes = append(changes, SchemaChange{Kind: "enum_value_added", Element: enumName + "." + value.GetName(), New: fmt.Sprint(value.GetNumber())})
+		}
+	}
+
+	return changes
+}
+
+// collectSchema returns every message and enum of the files by full name
+func collectSchema(files []*descriptorpb.FileDescriptorProto) (map[string]*descriptorpb.DescriptorProto, map[string]*descriptorpb.EnumDescriptorProto) {
+	messages := make(map[string]*descriptorpb.DescriptorProto)
+	enums := make(map[string]*descriptorpb.EnumDescriptorProto)
+
+	var collect func(msgs []*descriptorpb.DescriptorProto, prefix string)
+	collect = func(msgs []*descriptorpb.DescriptorProto, prefix string) {
+		for _, msg := range msgs {
+			name := prefix + "." + msg.GetName()
+			messages[name] = msg
+			for _, enum := range msg.EnumType {
+				enums[name+"."+enum.GetName()] = enum
+			}
+			collect(msg.NestedType, name)
+		}
+	}
+
+	for _, file := range files {
+		for _, enum := range file.EnumType {
+			enums[file.GetPackage()+"."+enum.GetName()] = enum
+		}
+		collect(file.MessageType, file.GetPackage())
+	}
+
+	return messages, enums
+}
+
+func fieldTypeName(field *descriptorpb.FieldDescriptorProto) string {
+	if field.TypeName != nil {
+		return strings.TrimPrefix(field.GetTypeName(), ".")
+	}
+	return strings.ToLower(strings.TrimPrefix(field.GetType().String(), "TYPE_"))
+}
+
+func labelName(field *descriptorpb.FieldDescriptorProto) string {
+	return strings.ToLower(strings.TrimPrefix(field.GetLabel().String(), "LABEL_"))
+}
+
+func describeField(field *descriptorpb.FieldDescriptorProto) string {
+	return fmt.Sprintf("%s %s %s = %d", labelName(field), fieldTypeName(field), field.GetName(), field.GetNumber())
+}
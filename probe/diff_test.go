@@ -0,0 +1,97 @@
+package probe
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestDiffSchemasMatchesFieldsByNumber(t *testing.T) {
+	file := func(fields ...*descriptorpb.FieldDescriptorProto) []*descriptorpb.FileDescriptorProto {
+		return []*descriptorpb.FileDescriptorProto{{
+			Package:     proto.String("google.internal.test.v1"),
+			MessageType: []*descriptorpb.DescriptorProto{{Name: proto.String("Item"), Field: fields}},
+		}}
+	}
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:   typ.Enum(),
+		}
+	}
+
+	oldFiles := file(
+		field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+		field("field_2", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+		field("field_3", 3, descriptorpb.FieldDescriptorProto_TYPE_INT32),
+		field("count", 4, descriptorpb.FieldDescriptorProto_TYPE_INT32),
+	)
+	newFiles := file(
+		field("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+		field("title", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+		field("size", 3, descriptorpb.FieldDescriptorProto_TYPE_INT64),
+		field("etag", 5, descriptorpb.FieldDescriptorProto_TYPE_STRING),
+	)
+
+	want := []SchemaChange{
+		{Kind: "field_removed", Element: "google.internal.test.v1.Item.count", Old: "optional int32 count = 4", Breaking: true},
+		{Kind: "field_added", Element: "google.internal.test.v1.Item.etag", New: "optional string etag = 5"},
+		{Kind: "field_renamed", Element: "google.internal.test.v1.Item.field_2", Old: "field_2", New: "title"},
+		{Kind: "field_renamed", Element: "google.internal.test.v1.Item.field_3", Old: "field_3", New: "size"},
+		{Kind: "field_type_changed", Element: "google.internal.test.v1.Item.field_3", Old: "int32", New: "int64", Breaking: true},
+	}
+	if got := DiffSchemas(oldFiles, newFiles); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+}
+
+func TestLoadDescriptorPathImports(t *testing.T) {
+	dir := t.TempDir()
+	importDir := t.TempDir()
+
+	// an output of --services, which imports google/api/annotations.proto, and a type of -import-path
+	files := map[string]string{
+		filepath.Join(importDir, "google/type/color.proto"): `syntax = "proto3";
+package google.type;
+message Color { float red = 1; }
+`,
+		filepath.Join(dir, "google/internal/test/v1/message.proto"): `syntax = "proto3";
+package google.internal.test.v1;
+import "google/type/color.proto";
+message CreateItemRequest { google.type.Color color = 1; }
+message CreateItemResponse {}
+`,
+		filepath.Join(dir, "google/internal/test/v1/service.proto"): `syntax = "proto3";
+package google.internal.test.v1;
+import "google/api/annotations.proto";
+import "google/internal/test/v1/message.proto";
+service ItemService {
+  rpc CreateItem(CreateItemRequest) returns (CreateItemResponse) {
+    option (google.api.http) = { post: "/v1/items:create" body: "*" };
+  }
+}
+`,
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	loaded, err := LoadDescriptorPath(dir, importDir)
+	if err != nil {
+		t.Fatalf("unable to load: %v", err)
+	}
+	if len(loaded) != 2 {
+		t.Errorf("want the 2 files of the directory, got %d", len(loaded))
+	}
+}
@@ -0,0 +1,170 @@
+package probe
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// openEnumSentinel is a number no real enum uses. If the server accepts it, the enum is open (proto3) and accepts any number,
+// so accepted numbers don't tell anything about the actual values.
+const openEnumSentinel = 536870911
+
+var (
+	// some frontends list the values of the enum when rejecting an invalid one, ex. "... (valid values: FOO = 1, BAR = 2)"
+	enumValueLeakRe = regexp.MustCompile(`\b([A-Z][A-Z0-9_]*)\s*[=:]\s*(-?\d+)\b`)
+)
+
+// enumTarget is an enum field discovered while probing, along with the index to send candidate values at
+type enumTarget struct {
+	Package     string `json:"package"`
+	Enum        string `json:"enum"`
+	FieldName   string `json:"field_name"`
+	FieldNumber int32  `json:"field_number,omitempty"`
+	Index       []int  `json:"index"`
+}
+
+func (s *session) addEnumTarget(packageName string, enumName string, fieldName string, fieldNumber int32, index []int) {
+	for _, target := range s.enumTargets {
+		if target.Package == packageName && target.Enum == enumName {
+			return
+		}
+	}
+	s.enumTargets = append(s.enumTargets, enumTarget{Package: packageName, Enum: enumName, FieldName: fieldName, FieldNumber: fieldNumber, Index: append([]int(nil), index...)})
+}
+
+// probeEnumValues sends candidate names and the numbers up to maxValue at each enum field, and replaces the synthetic
+// UNKNOWN_ value with whatever the server accepts or leaks
+func (s *session) probeEnumValues(maxValue int) {
+	for _, target := range s.enumTargets {
+		fdproto, ok := s.packageFDProtoMap[target.Package]
+		if !ok {
+			continue
+		}
+		_, enum, err := getOrCreateMessageDescriptor(fdproto, target.Enum)
+		if err != nil || enum == nil {
+			continue
+		}
+
+		field := &descriptorpb.FieldDescriptorProto{Name: proto.String(target.FieldName), Number: proto.Int32(target.FieldNumber)}
+		if target.FieldNumber == 0 {
+			// older checkpoints don't have the number, which unnamed fields are reported by
+			var number int32
+			if _, err := fmt.Sscanf(target.FieldName, "field_%d", &number); err == nil {
+				field.Number = proto.Int32(number)
+			}
+		}
+		leaked := make(map[int32]string)
+		accepts := func(value interface{}) bool {
+			violations, err := s.probeAPI(genValuePayload(target.Index, value))
+			if err != nil {
+				s.logger.Error().Err(err).Str("enum", target.Enum).Msg("error when probing enum value")
+				return false
+			}
+			return !enumValueRejected(violations, field, leaked)
+		}
+
+		// names following the usual conventions for the zero value
+		enumPrefix := strings.TrimPrefix(convertToUnknownType(enum.GetName()), "UNKNOWN_")
+		zeroName := ""
+		for _, candidate := range []string{enumPrefix + "_UNSPECIFIED", "UNKNOWN_" + enumPrefix, enumPrefix + "_UNKNOWN", "UNSPECIFIED", "UNKNOWN"} {
+			if accepts(candidate) {
+				zeroName = candidate
+				break
+			}
+		}
+
+		var numbers []int32
+		if !accepts(openEnumSentinel) {
+			for n := 1; n <= maxValue; n++ {
+				if accepts(n) {
+					numbers = append(numbers, int32(n))
+				}
+			}
+		} else if s.verbose {
+			s.logger.Debug().Str("enum", target.Enum).Msg("enum accepts any number, skipping number probing")
+		}
+
+		applyEnumValues(enum, fdproto.GetSyntax() != "proto2", zeroName, numbers, leaked)
+
+		if s.verbose {
+			s.logger.Debug().Str("enum", target.Enum).Int("values", len(enum.Value)).Msg("probed enum values")
+		}
+	}
+}
+
+// enumValueRejected tells whether one of the violations is about the enum field, and adds the values listed by those
+// violations to leaked. The violations of other fields are ignored, their text may look like a list of values.
+func enumValueRejected(violations []FieldViolation, field *descriptorpb.FieldDescriptorProto, leaked map[int32]string) bool {
+	rejected := false
+	for _, violation := range violations {
+		if !isViolationOf(violation.Field, field) {
+			continue
+		}
+		rejected = true
+
+		for _, match := range enumValueLeakRe.FindAllStringSubmatch(violation.Description, -1) {
+			if number, err := strconv.Atoi(match[2]); err == nil {
+				leaked[int32(number)] = match[1]
+			}
+		}
+	}
+	return rejected
+}
+
+// applyEnumValues replaces the values of the enum. Leaked names take precedence over the VALUE_n placeholders, which are
+// prefixed with the enum name as enum values share their scope with the values of sibling enums. The synthetic zero
+// value is only kept for proto3, proto2 enums are closed and don't need one.
+func applyEnumValues(enum *descriptorpb.EnumDescriptorProto, proto3 bool, zeroName string, numbers []int32, leaked map[int32]string) {
+	enumPrefix := strings.TrimPrefix(convertToUnknownType(enum.GetName()), "UNKNOWN_")
+	unknownName := convertToUnknownType(enum.GetName())
+
+	names := make(map[int32]string)
+	for _, value := range enum.Value {
+		if !proto3 && value.GetName() == unknownName && value.GetNumber() == 0 {
+			continue
+		}
+		names[value.GetNumber()] = value.GetName()
+	}
+
+	if zeroName != "" {
+		names[0] = zeroName
+	}
+	for _, number := range numbers {
+		if _, ok := names[number]; !ok {
+			names[number] = fmt.Sprintf("%s_VALUE_%d", enumPrefix, number)
+		}
+	}
+	for number, name := range leaked {
+		names[number] = name
+	}
+
+	// proto3 requires the first value to be zero, and an enum needs a value either way
+	if _, ok := names[0]; !ok && (proto3 || len(names) == 0) {
+		names[0] = unknownName
+	}
+
+	sorted := make([]int32, 0, len(names))
+	for number := range names {
+		sorted = append(sorted, number)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	enum.Value = nil
+	usedNames := make(map[string]bool)
+	for _, number := range sorted {
+		name := names[number]
+		if usedNames[name] {
+			name = fmt.Sprintf("%s_%d", name, number)
+		}
+		usedNames[name] = true
+		enum.Value = append(enum.Value, &descriptorpb.EnumValueDescriptorProto{Name: proto.String(name), Number: proto.Int32(number)})
+	}
+}
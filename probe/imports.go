@@ -0,0 +1,228 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	// well-known types, registered in protoregistry.GlobalFiles
+	_ "google.golang.org/protobuf/types/known/anypb"
+	_ "google.golang.org/protobuf/types/known/durationpb"
+	_ "google.golang.org/protobuf/types/known/emptypb"
+	_ "google.golang.org/protobuf/types/known/fieldmaskpb"
+	_ "google.golang.org/protobuf/types/known/structpb"
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+	_ "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// resolveExternalType finds a message or enum that is already defined outside of the probed packages,
+// either a well-known type (google.protobuf.*) or a type from --import-path
+func (s *session) resolveExternalType(typeName string) (protoreflect.Descriptor, bool) {
+	if strings.HasPrefix(typeName, "google.protobuf.") {
+		if desc, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(typeName)); err == nil {
+			return desc, true
+		}
+	}
+
+	desc, err := s.importedFiles.FindDescriptorByName(protoreflect.FullName(typeName))
+	if err != nil {
+		return nil, false
+	}
+
+	switch desc.(type) {
+	case protoreflect.MessageDescriptor, protoreflect.EnumDescriptor:
+		return desc, true
+	}
+	return nil, false
+}
+
+// findExternalFile returns an already defined file by its import path
+func (s *session) findExternalFile(path string) (protoreflect.FileDescriptor, bool) {
+	if fd, err := s.importedFiles.FindFileByPath(path); err == nil {
+		return fd, true
+	}
+	if fd, err := protoregistry.GlobalFiles.FindFileByPath(path); err == nil {
+		return fd, true
+	}
+	if files, err := googleAPIRegistry(); err == nil && strings.HasPrefix(path, "google/api/") {
+		if fd, err := files.FindFileByPath(path); err == nil {
+			return fd, true
+		}
+	}
+	return nil, false
+}
+
+// loadImportPath loads the definitions at path into s.importedFiles
+func (s *session) loadImportPath(path string) error {
+	files, err := LoadDescriptorPath(path)
+	if err != nil {
+		return err
+	}
+
+	for _, fd := range files {
+		if err := registerFileWithImports(s.importedFiles, fd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadDescriptorPath loads existing definitions, either .proto files or a binary FileDescriptorSet (.pb, .binpb, ...).
+// A directory is used as the import root of every .proto file below it, a single .proto file is compiled with its own
+// directory as the import root. Imports are also looked up in importPaths, then in the well-known types and the
+// google/api files the services import.
+func LoadDescriptorPath(path string, importPaths ...string) ([]protoreflect.FileDescriptor, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() && filepath.Ext(path) != ".proto" {
+		return loadDescriptorSet(path)
+	}
+
+	root := path
+	var files []string
+	if info.IsDir() {
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || filepath.Ext(p) != ".proto" {
+				return err
+			}
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(rel))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		root = filepath.Dir(path)
+		files = []string{filepath.Base(path)}
+	}
+
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(protocompile.CompositeResolver{
+			&protocompile.SourceResolver{ImportPaths: append([]string{root}, importPaths...)},
+			&protocompile.SourceResolver{Accessor: openGoogleAPIProto},
+		}),
+	}
+	compiled, err := compiler.Compile(context.Background(), files...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]protoreflect.FileDescriptor, 0, len(compiled))
+	for _, fd := range compiled {
+		result = append(result, fd)
+	}
+	return result, nil
+}
+
+func loadDescriptorSet(path string) ([]protoreflect.FileDescriptor, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	descSet := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(b, descSet); err != nil {
+		return nil, fmt.Errorf("unable to parse descriptor set %s: %w", path, err)
+	}
+
+	// the well-known types are left out of some sets, so they're resolved from the global registry
+	resolver := &fallbackResolver{primary: &protoregistry.Files{}, fallback: protoregistry.GlobalFiles}
+	fileOptions := protodesc.FileOptions{AllowUnresolvable: true}
+
+	var result []protoreflect.FileDescriptor
+	for _, fdproto := range sortFilesTopologically(descSet.File) {
+		if _, err := resolver.FindFileByPath(fdproto.GetName()); err == nil {
+			continue
+		}
+		fd, err := fileOptions.New(fdproto, resolver)
+		if err != nil {
+			return nil, err
+		}
+		if err := resolver.primary.RegisterFile(fd); err != nil {
+			return nil, err
+		}
+		result = append(result, fd)
+	}
+	return result, nil
+}
+
+// fallbackResolver looks up descriptors in primary first, then in fallback
+type fallbackResolver struct {
+	primary  *protoregistry.Files
+	fallback *protoregistry.Files
+}
+
+func (r *fallbackResolver) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	if fd, err := r.primary.FindFileByPath(path); err == nil {
+		return fd, nil
+	}
+	return r.fallback.FindFileByPath(path)
+}
+
+func (r *fallbackResolver) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	if desc, err := r.primary.FindDescriptorByName(name); err == nil {
+		return desc, nil
+	}
+	return r.fallback.FindDescriptorByName(name)
+}
+
+// registerFileWithImports registers a file and everything it imports, skipping files that are already registered
+func registerFileWithImports(files *protoregistry.Files, fd protoreflect.FileDescriptor) error {
+	if _, err := files.FindFileByPath(fd.Path()); err == nil {
+		return nil
+	}
+
+	for i := 0; i < fd.Imports().Len(); i++ {
+		if err := registerFileWithImports(files, fd.Imports().Get(i).FileDescriptor); err != nil {
+			return err
+		}
+	}
+
+	return files.RegisterFile(fd)
+}
+
+// externalDependencies returns the external files (and their imports) the given files depend on, as descriptor protos
+// so they can be included in a FileDescriptorSet
+func (s *session) externalDependencies(fdprotos []*descriptorpb.FileDescriptorProto) []*descriptorpb.FileDescriptorProto {
+	var result []*descriptorpb.FileDescriptorProto
+	added := make(map[string]bool)
+
+	var add func(fd protoreflect.FileDescriptor)
+	add = func(fd protoreflect.FileDescriptor) {
+		if added[fd.Path()] {
+			return
+		}
+		added[fd.Path()] = true
+		for i := 0; i < fd.Imports().Len(); i++ {
+			add(fd.Imports().Get(i).FileDescriptor)
+		}
+		result = append(result, protodesc.ToFileDescriptorProto(fd))
+	}
+
+	for _, fdproto := range fdprotos {
+		for _, dep := range fdproto.Dependency {
+			if fd, ok := s.findExternalFile(dep); ok {
+				add(fd)
+			}
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,43 @@
+package probe
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/reflect/protodesc"
+)
+
+func TestProbeWithImportPaths(t *testing.T) {
+	_, probed := countTestSchemaRequests(t)
+	set, imported := countTestSchemaRequests(t, WithImportPaths("testdata/schema/common.proto"))
+	if imported >= probed {
+		t.Errorf("want fewer requests with the imported Metadata, got %d (%d without)", imported, probed)
+	}
+	if _, err := protodesc.NewFiles(set); err != nil {
+		t.Fatalf("output doesn't resolve: %v", err)
+	}
+
+	// Metadata is imported from its own file rather than generated in a file of the package
+	files := make(map[string][]string)
+	for _, file := range set.File {
+		files[file.GetName()] = file.Dependency
+	}
+	if _, ok := files[FileName("google.internal.test.common")]; ok {
+		t.Errorf("Metadata was probed into a file of its package")
+	}
+	if _, ok := files["common.proto"]; !ok {
+		t.Errorf("common.proto isn't part of the output")
+	}
+	imports := false
+	for _, dep := range files[FileName("google.internal.test.v1")] {
+		imports = imports || dep == "common.proto"
+	}
+	if !imports {
+		t.Errorf("request file doesn't import common.proto: %v", files[FileName("google.internal.test.v1")])
+	}
+
+	got := describeSchema(set.File, testRequest)["google.internal.test.common.Metadata"]
+	if want := []string{"optional string etag = 1", "optional sint64 version = 2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Metadata: want the imported fields %v, got %v", want, got)
+	}
+}
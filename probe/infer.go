@@ -0,0 +1,221 @@
+package probe
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const errorInfoTypeURL = "type.googleapis.com/google.rpc.ErrorInfo"
+
+// the type of the request, in the violation of a payload that isn't a message at all
+var rootTypeURLRe = regexp.MustCompile(`\(type\.googleapis\.com/([\w.]+)\)`)
+
+// inferRequestType finds the type of the request when it wasn't given. The server names it when the whole payload
+// isn't a message, otherwise it's guessed from the gRPC method of an ErrorInfo, which is usually
+// `<package>.<Service>.<Method>` for a request named `<package>.<Method>Request`.
+func (s *session) inferRequestType() (string, error) {
+	violations, err := s.probeAPI(genValuePayload(nil, "str"))
+	if err != nil {
+		return "", err
+	}
+	for _, violation := range violations {
+		if violation.Field != "" {
+			continue
+		}
+		if match := rootTypeURLRe.FindStringSubmatch(violation.Description); match != nil && messageRe.MatchString(match[1]) {
+			s.logger.Info().Str("type", match[1]).Msg("request type named by the server")
+			return match[1], nil
+		}
+	}
+
+	if s.rpcMethod == "" {
+		return "", fmt.Errorf("the server doesn't reveal the request type, set it by hand")
+	}
+
+	rootType, err := requestTypeForMethod(s.rpcMethod)
+	if err != nil {
+		return "", err
+	}
+	s.logger.Info().Str("method", s.rpcMethod).Str("type", rootType).Msg("request type inferred from the rpc method")
+	return rootType, nil
+}
+
+// errorMethod returns the gRPC method named by an ErrorInfo in the first response that has one
+func (s *session) errorMethod(responses ...*Response) string {
+	for _, resp := range responses {
+		method, err := parseErrorMethod(resp)
+		if err != nil {
+			s.logger.Debug().Err(err).Msg("unable to parse error info")
+			continue
+		}
+		if method != "" {
+			return method
+		}
+	}
+	return ""
+}
+
+// requestTypeForMethod turns a gRPC method (ex. google.internal.people.v2.InternalPeopleService.InsertPerson) into
+// the conventional name of its request (google.internal.people.v2.InsertPersonRequest)
+func requestTypeForMethod(method string) (string, error) {
+	packageName, _, methodName, err := splitMethod(method)
+	if err != nil {
+		return "", err
+	}
+
+	rootType := packageName + "." + methodName + "Request"
+	if !messageRe.MatchString(rootType) {
+		return "", fmt.Errorf("unexpected rpc method %q", method)
+	}
+	return rootType, nil
+}
+
+// splitMethod splits a gRPC method into its package, service and method names. The method can also be written as a
+// path, ex. /google.example.v1.ExampleService/GetThing.
+func splitMethod(method string) (packageName string, serviceName string, methodName string, err error) {
+	parts := strings.Split(strings.ReplaceAll(strings.TrimPrefix(method, "/"), "/", "."), ".")
+	if len(parts) < 3 {
+		return "", "", "", fmt.Errorf("unexpected rpc method %q", method)
+	}
+	for _, part := range parts {
+		if part == "" {
+			return "", "", "", fmt.Errorf("unexpected rpc method %q", method)
+		}
+	}
+
+	return strings.Join(parts[:len(parts)-2], "."), parts[len(parts)-2], parts[len(parts)-1], nil
+}
+
+// parseErrorMethod returns the method in the metadata of an ErrorInfo in the response, or an empty string if there
+// isn't any
+func parseErrorMethod(resp *Response) (string, error) {
+	contentType := resp.Header.Get("Content-Type")
+
+	var metadata map[string]string
+	var err error
+	if strings.Contains(contentType, "application/json+protobuf") {
+		metadata, err = parseJSPBErrorInfo(resp.Body)
+	} else if strings.Contains(contentType, "application/json") {
+		var response ErrorResponse
+		if err := json.Unmarshal(resp.Body, &response); err != nil {
+			return "", err
+		}
+		for _, detail := range response.Error.Details {
+			if detail.Type == errorInfoTypeURL {
+				metadata = detail.Metadata
+				break
+			}
+		}
+	} else if strings.Contains(contentType, "application/x-protobuf") {
+		metadata, err = parseProtoErrorInfo(resp.Body)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return metadata["method"], nil
+}
+
+// parseJSPBErrorInfo returns the metadata of the first google.rpc.ErrorInfo of a JSPB status:
+//
+//	google.rpc.ErrorInfo { string reason = 1; string domain = 2; map<string, string> metadata = 3; }
+//
+// Maps are arrays of [key, value] pairs.
+func parseJSPBErrorInfo(body []byte) (map[string]string, error) {
+	var status []json.RawMessage
+	if err := json.Unmarshal(body, &status); err != nil {
+		return nil, fmt.Errorf("unable to parse protojson status: %w", err)
+	}
+
+	details := jspbField(status, 3)
+	if details == nil {
+		return nil, nil
+	}
+
+	var anys [][]json.RawMessage
+	if err := json.Unmarshal(details, &anys); err != nil {
+		return nil, fmt.Errorf("unable to parse protojson status details: %w", err)
+	}
+
+	for _, detail := range anys {
+		if len(detail) == 0 || jspbString(detail[0]) != errorInfoTypeURL {
+			continue
+		}
+
+		entries := jspbField(detail[1:], 3)
+		if entries == nil {
+			return nil, nil
+		}
+
+		var pairs [][]json.RawMessage
+		if err := json.Unmarshal(entries, &pairs); err != nil {
+			return nil, fmt.Errorf("unable to parse protojson error info: %w", err)
+		}
+
+		metadata := make(map[string]string, len(pairs))
+		for _, pair := range pairs {
+			if len(pair) == 2 {
+				metadata[jspbString(pair[0])] = jspbString(pair[1])
+			}
+		}
+		return metadata, nil
+	}
+
+	return nil, nil
+}
+
+// parseProtoErrorInfo returns the metadata of the first google.rpc.ErrorInfo of a binary status, map entries being
+// messages with the key as field 1 and the value as field 2
+func parseProtoErrorInfo(body []byte) (map[string]string, error) {
+	var metadata map[string]string
+
+	err := rangeProtoFields(body, func(num protowire.Number, value []byte) error {
+		if num != 3 || metadata != nil {
+			return nil
+		}
+
+		var typeURL string
+		var packed []byte
+		err := rangeProtoFields(value, func(num protowire.Number, value []byte) error {
+			switch num {
+			case 1:
+				typeURL = string(value)
+			case 2:
+				packed = value
+			}
+			return nil
+		})
+		if err != nil || typeURL != errorInfoTypeURL {
+			return err
+		}
+
+		metadata = make(map[string]string)
+		return rangeProtoFields(packed, func(num protowire.Number, value []byte) error {
+			if num != 3 {
+				return nil
+			}
+
+			var key, val string
+			err := rangeProtoFields(value, func(num protowire.Number, value []byte) error {
+				switch num {
+				case 1:
+					key = string(value)
+				case 2:
+					val = string(value)
+				}
+				return nil
+			})
+			metadata[key] = val
+			return err
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse proto status: %w", err)
+	}
+
+	return metadata, nil
+}
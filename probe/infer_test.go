@@ -0,0 +1,72 @@
+package probe
+
+import (
+	"context"
+	"req2proto/gapitest"
+	"testing"
+)
+
+func TestProbeInfersRequestType(t *testing.T) {
+	tests := []struct {
+		name            string
+		method          string
+		hideRequestType bool
+		jspbErrors      bool
+		alt             string
+		wantErr         bool
+	}{
+		{name: "type url", alt: "json"},
+		{name: "type url jspb", jspbErrors: true, alt: "auto"},
+		{name: "error info json", method: "google.internal.test.v1.ItemService.CreateItem", hideRequestType: true, alt: "json"},
+		{name: "error info proto", method: "google.internal.test.v1.ItemService.CreateItem", hideRequestType: true, alt: "proto"},
+		{name: "error info jspb", method: "google.internal.test.v1.ItemService.CreateItem", hideRequestType: true, jspbErrors: true, alt: "auto"},
+		{name: "hidden", hideRequestType: true, alt: "json", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := gapitest.NewServer(loadTestSchema(t), testRequest)
+			if err != nil {
+				t.Fatalf("unable to create fake server: %v", err)
+			}
+			server.Method = tt.method
+			server.HideRequestType = tt.hideRequestType
+			server.JSPBErrors = tt.jspbErrors
+
+			p := New(WithTransport(NewHandlerTransport(server)), WithRetries(0), WithResponseEncoding(tt.alt))
+			set, err := p.Probe(context.Background(), testURL, "")
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("want an error when the server doesn't reveal the request type")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("probe failed: %v", err)
+			}
+
+			diffDescriptions(t, describeSchema(loadTestSchema(t).File, testRequest), describeSchema(set.File, testRequest))
+		})
+	}
+}
+
+func TestRequestTypeForMethod(t *testing.T) {
+	tests := map[string]string{
+		"google.internal.people.v2.InternalPeopleService.InsertPerson": "google.internal.people.v2.InsertPersonRequest",
+		"/google.example.v1.ExampleService/GetThing":                   "google.example.v1.GetThingRequest",
+		"ExampleService.GetThing":                                      "",
+	}
+
+	for method, want := range tests {
+		got, err := requestTypeForMethod(method)
+		if want == "" {
+			if err == nil {
+				t.Errorf("%s: want an error, got %s", method, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("%s: want %s, got %s (%v)", method, want, got, err)
+		}
+	}
+}
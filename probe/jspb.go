@@ -0,0 +1,91 @@
+package probe
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const badRequestTypeURL = "type.googleapis.com/google.rpc.BadRequest"
+
+// parseJSPBErrorResponse extracts the field violations out of a google.rpc.Status in JSPB (protojson) form.
+//
+// Every message is an array where position n-1 holds field n, so a status looks like:
+//
+//	[3, "Request contains an invalid argument.", [["type.googleapis.com/google.rpc.BadRequest", [["field", "description"], ...]]]]
+//
+// The details are google.protobuf.Any, which is encoded as the type url followed by the fields of the packed message.
+func parseJSPBErrorResponse(body []byte) ([]FieldViolation, error) {
+	var status []json.RawMessage
+	if err := json.Unmarshal(body, &status); err != nil {
+		return nil, fmt.Errorf("unable to parse protojson status: %w", err)
+	}
+
+	// details is field 3 of google.rpc.Status
+	details := jspbField(status, 3)
+	if details == nil {
+		return nil, nil
+	}
+
+	var anys [][]json.RawMessage
+	if err := json.Unmarshal(details, &anys); err != nil {
+		return nil, fmt.Errorf("unable to parse protojson status details: %w", err)
+	}
+
+	var violations []FieldViolation
+	for _, detail := range anys {
+		if len(detail) == 0 || jspbString(detail[0]) != badRequestTypeURL {
+			continue
+		}
+
+		// field_violations is field 1 of google.rpc.BadRequest, which comes right after the type url
+		fieldViolations := jspbField(detail[1:], 1)
+		if fieldViolations == nil {
+			continue
+		}
+
+		var entries [][]json.RawMessage
+		if err := json.Unmarshal(fieldViolations, &entries); err != nil {
+			return nil, fmt.Errorf("unable to parse protojson field violations: %w", err)
+		}
+
+		for _, entry := range entries {
+			violations = append(violations, FieldViolation{
+				Field:       jspbString(jspbField(entry, 1)),
+				Description: jspbString(jspbField(entry, 2)),
+			})
+		}
+	}
+
+	return violations, nil
+}
+
+// jspbField returns the raw value of the field with the given number, or nil if it isn't set.
+// Fields that don't fit in the array are stored in a trailing object keyed by the field number.
+func jspbField(msg []json.RawMessage, number int) json.RawMessage {
+	if number-1 < len(msg) {
+		value := msg[number-1]
+		if len(value) > 0 && value[0] != '{' && string(value) != "null" {
+			return value
+		}
+	}
+
+	if len(msg) > 0 {
+		var sparse map[string]json.RawMessage
+		if json.Unmarshal(msg[len(msg)-1], &sparse) == nil {
+			if value, ok := sparse[fmt.Sprint(number)]; ok && string(value) != "null" {
+				return value
+			}
+		}
+	}
+
+	return nil
+}
+
+// jspbString returns the value as a string, or an empty string if it isn't one
+func jspbString(value json.RawMessage) string {
+	var s string
+	if json.Unmarshal(value, &s) != nil {
+		return ""
+	}
+	return s
+}
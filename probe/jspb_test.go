@@ -0,0 +1,103 @@
+package probe
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseJSPBErrorResponse(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []FieldViolation
+	}{
+		{
+			name: "bad request",
+			body: `[3,"Request contains an invalid argument.",[["type.googleapis.com/google.rpc.BadRequest",[["item.title","Invalid value at 'item.title' (TYPE_STRING), 1"],["item.count","Invalid value at 'item.count' (TYPE_INT32), \"x\""]]]]]`,
+			want: []FieldViolation{
+				{Field: "item.title", Description: "Invalid value at 'item.title' (TYPE_STRING), 1"},
+				{Field: "item.count", Description: "Invalid value at 'item.count' (TYPE_INT32), \"x\""},
+			},
+		},
+		{
+			// the BadRequest comes after the ErrorInfo, and a violation of the whole payload has no field
+			name: "error info first",
+			body: `[3,"Invalid JSON payload received. Expected an array.",[["type.googleapis.com/google.rpc.ErrorInfo","INVALID_ARGUMENT","googleapis.com",[["method","google.internal.test.v1.ItemService.CreateItem"],["service","test-pa.googleapis.com"]]],["type.googleapis.com/google.rpc.BadRequest",[[null,"Invalid value (type.googleapis.com/google.internal.test.v1.CreateItemRequest), 1"]]]]]`,
+			want: []FieldViolation{
+				{Description: "Invalid value (type.googleapis.com/google.internal.test.v1.CreateItemRequest), 1"},
+			},
+		},
+		{
+			// fields past the end of the array are kept in a trailing object keyed by number
+			name: "sparse",
+			body: `[3,"Request contains an invalid argument.",null,{"3":[["type.googleapis.com/google.rpc.BadRequest",[["name","Invalid value at 'name' (TYPE_STRING), 1"]]]]}]`,
+			want: []FieldViolation{
+				{Field: "name", Description: "Invalid value at 'name' (TYPE_STRING), 1"},
+			},
+		},
+		{
+			name: "no details",
+			body: `[5,"Requested entity was not found."]`,
+		},
+		{
+			name: "other details",
+			body: `[3,"Request contains an invalid argument.",[["type.googleapis.com/google.rpc.DebugInfo",["frame"],"detail"]]]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseJSPBErrorResponse([]byte(tt.body))
+			if err != nil {
+				t.Fatalf("unable to parse: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := parseJSPBErrorResponse([]byte(`{"error":{}}`)); err == nil {
+		t.Errorf("want an error for a JSON status")
+	}
+}
+
+func TestParseJSPBErrorInfo(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want map[string]string
+	}{
+		{
+			name: "error info",
+			body: `[3,"Request contains an invalid argument.",[["type.googleapis.com/google.rpc.BadRequest",[["name","Invalid value at 'name' (TYPE_STRING), 1"]]],["type.googleapis.com/google.rpc.ErrorInfo","INVALID_ARGUMENT","googleapis.com",[["method","google.internal.test.v1.ItemService.CreateItem"],["service","test-pa.googleapis.com"]]]]]`,
+			want: map[string]string{"method": "google.internal.test.v1.ItemService.CreateItem", "service": "test-pa.googleapis.com"},
+		},
+		{
+			// the reason and the domain are unset
+			name: "no reason",
+			body: `[7,"The caller does not have permission",[["type.googleapis.com/google.rpc.ErrorInfo",null,null,[["method","google.example.v1.ExampleService.GetThing"]]]]]`,
+			want: map[string]string{"method": "google.example.v1.ExampleService.GetThing"},
+		},
+		{
+			name: "no metadata",
+			body: `[3,"Request contains an invalid argument.",[["type.googleapis.com/google.rpc.ErrorInfo","INVALID_ARGUMENT","googleapis.com"]]]`,
+		},
+		{
+			name: "bad request only",
+			body: `[3,"Request contains an invalid argument.",[["type.googleapis.com/google.rpc.BadRequest",[["name","Invalid value at 'name' (TYPE_STRING), 1"]]]]]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseJSPBErrorInfo([]byte(tt.body))
+			if err != nil {
+				t.Fatalf("unable to parse: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package probe
+
+import (
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+type knownType struct {
+	Package string
+	Type    *descriptorpb.FieldDescriptorProto_Type
+}
+
+// loadKnownPath seeds s.packageFDProtoMap with the definitions at path, merging them per package
+func (s *session) loadKnownPath(path string) error {
+	files, err := LoadDescriptorPath(path)
+	if err != nil {
+		return err
+	}
+
+	seeded := make(map[string]bool)
+	var seed func(fd protoreflect.FileDescriptor)
+	seed = func(fd protoreflect.FileDescriptor) {
+		if seeded[fd.Path()] || strings.HasPrefix(fd.Path(), "google/protobuf/") {
+			return
+		}
+		seeded[fd.Path()] = true
+
+		for i := 0; i < fd.Imports().Len(); i++ {
+			seed(fd.Imports().Get(i).FileDescriptor)
+		}
+		s.seedKnownFile(fd)
+	}
+
+	for _, fd := range files {
+		seed(fd)
+	}
+	return nil
+}
+
+func (s *session) seedKnownFile(fd protoreflect.FileDescriptor) {
+	packageName := string(fd.Package())
+	known := protodesc.ToFileDescriptorProto(fd)
+
+	fdproto, ok := s.packageFDProtoMap[packageName]
+	if !ok {
+		fdproto = &descriptorpb.FileDescriptorProto{
+			Name:    proto.String(FileName(packageName)),
+			Syntax:  proto.String("proto3"),
+			Package: proto.String(packageName),
+		}
+		s.packageFDProtoMap[packageName] = fdproto
+	}
+	if fd.Syntax() == protoreflect.Proto2 {
+		fdproto.Syntax = proto.String("proto2")
+	}
+
+	// every package is generated as a single file, so imports are rewritten to those files
+	for i := 0; i < fd.Imports().Len(); i++ {
+		imp := fd.Imports().Get(i)
+		if strings.HasPrefix(imp.Path(), "google/protobuf/") {
+			s.addPackageDependency(packageName, imp.Path())
+		} else if impPackage := string(imp.Package()); impPackage != packageName {
+			s.addPackageDependency(packageName, FileName(impPackage))
+		}
+	}
+
+	for _, enum := range known.EnumType {
+		s.knownTypes[packageName+"."+enum.GetName()] = knownType{Package: packageName, Type: descriptorpb.FieldDescriptorProto_TYPE_ENUM.Enum()}
+		if findEnum(&fdproto.EnumType, enum.GetName()) == nil {
+			fdproto.EnumType = append(fdproto.EnumType, enum)
+		}
+	}
+
+	for _, msg := range known.MessageType {
+		s.collectKnownTypes(msg, packageName, packageName)
+		fdproto.MessageType = mergeMessage(fdproto.MessageType, msg)
+	}
+}
+
+func (s *session) collectKnownTypes(msg *descriptorpb.DescriptorProto, packageName string, parentPath string) {
+	currentPath := parentPath + "." + msg.GetName()
+	s.knownTypes[currentPath] = knownType{Package: packageName, Type: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()}
+
+	for _, enum := range msg.EnumType {
+		s.knownTypes[currentPath+"."+enum.GetName()] = knownType{Package: packageName, Type: descriptorpb.FieldDescriptorProto_TYPE_ENUM.Enum()}
+	}
+	for _, nested := range msg.NestedType {
+		s.collectKnownTypes(nested, packageName, currentPath)
+	}
+}
+
+// mergeMessage adds msg to messages, or merges its fields, enums and nested messages into the message with the same name
+func mergeMessage(messages []*descriptorpb.DescriptorProto, msg *descriptorpb.DescriptorProto) []*descriptorpb.DescriptorProto {
+	var existing *descriptorpb.DescriptorProto
+	for _, i := range messages {
+		if i.GetName() == msg.GetName() {
+			existing = i
+			break
+		}
+	}
+	if existing == nil {
+		return append(messages, msg)
+	}
+
+	present := make(map[int32]bool, len(existing.Field))
+	for _, field := range existing.Field {
+		present[field.GetNumber()] = true
+	}
+	oneofIndexes := make(map[int32]int32)
+	for _, field := range msg.Field {
+		if present[field.GetNumber()] {
+			continue
+		}
+
+		// oneofs are declared per message, so the index has to point to the declaration in existing
+		if field.OneofIndex != nil {
+			index, ok := oneofIndexes[field.GetOneofIndex()]
+			if !ok {
+				index = int32(len(existing.OneofDecl))
+				existing.OneofDecl = append(existing.OneofDecl, msg.OneofDecl[field.GetOneofIndex()])
+				oneofIndexes[field.GetOneofIndex()] = index
+			}
+			field.OneofIndex = proto.Int32(index)
+		}
+		existing.Field = append(existing.Field, field)
+	}
+
+	for _, enum := range msg.EnumType {
+		if findEnum(&existing.EnumType, enum.GetName()) == nil {
+			existing.EnumType = append(existing.EnumType, enum)
+		}
+	}
+	for _, nested := range msg.NestedType {
+		existing.NestedType = mergeMessage(existing.NestedType, nested)
+	}
+
+	return messages
+}
@@ -0,0 +1,27 @@
+package probe
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProbeWithKnown(t *testing.T) {
+	_, probed := countTestSchemaRequests(t)
+	set, known := countTestSchemaRequests(t, WithKnown("testdata/known/common.proto"))
+	if known >= probed {
+		t.Errorf("want fewer requests with the known Metadata, got %d (%d without)", known, probed)
+	}
+
+	// the known definition is kept as is, including the field the server doesn't report
+	got := describeSchema(set.File, testRequest)["google.internal.test.common.Metadata"]
+	want := []string{"optional string etag = 1", "optional sint64 version = 2", "optional string source = 3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Metadata: want the known fields %v, got %v", want, got)
+	}
+
+	// the rest is still probed
+	want = describeSchema(loadTestSchema(t).File, testRequest)[testRequest]
+	if got := describeSchema(set.File, testRequest)[testRequest]; !reflect.DeepEqual(got, want) {
+		t.Errorf("request:\nwant %q\ngot  %q", want, got)
+	}
+}
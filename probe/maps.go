@@ -0,0 +1,150 @@
+package probe
+
+import (
+	"strings"
+	"unicode"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// detectMapEntries marks entry-shaped messages as map entries, so the fields using them are generated as map<K, V>.
+//
+// On the wire, a map is a repeated message named <FieldName>Entry nested in the parent, with a key = 1 and a value = 2.
+// If the key wasn't discovered during the crawl (ex. because of -d), it is probed here.
+func (s *session) detectMapEntries() {
+	files := s.packageFiles()
+	messages, _ := collectSchema(files)
+	indices := messageIndices(s.rootMessage, messages)
+
+	for _, fdproto := range files {
+		s.detectMessageMapEntries(fdproto.MessageType, fdproto.GetPackage(), indices)
+	}
+}
+
+func (s *session) detectMessageMapEntries(messages []*descriptorpb.DescriptorProto, parentPath string, indices map[string][]int) {
+	for _, msg := range messages {
+		currentPath := parentPath + "." + msg.GetName()
+
+		for _, field := range msg.Field {
+			if field.GetLabel() != descriptorpb.FieldDescriptorProto_LABEL_REPEATED || field.GetType() != descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+				continue
+			}
+
+			entryName := mapEntryName(field.GetName())
+			if field.GetTypeName() != "."+currentPath+"."+entryName {
+				continue
+			}
+
+			var entry *descriptorpb.DescriptorProto
+			for _, nested := range msg.NestedType {
+				if nested.GetName() == entryName {
+					entry = nested
+					break
+				}
+			}
+			if entry == nil {
+				continue
+			}
+
+			key, value := findFieldByNumber(entry, 1), findFieldByNumber(entry, 2)
+			if key == nil && value != nil {
+				if index, ok := indices[currentPath]; ok {
+					key = s.probeMapKey(entry, append(append(append([]int(nil), index...), int(field.GetNumber())), 1))
+				}
+			}
+
+			if key == nil || value == nil || len(entry.Field) != 2 || !isValidMapKey(key) {
+				continue
+			}
+
+			key.Name, key.JsonName = proto.String("key"), proto.String("key")
+			value.Name, value.JsonName = proto.String("value"), proto.String("value")
+			key.Label = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+			value.Label = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+			// the value can be discovered first, but the key comes first in an entry
+			entry.Field = []*descriptorpb.FieldDescriptorProto{key, value}
+			entry.Options = &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)}
+			s.noteFieldDiscovery(field, "detected as a map, as %s has the shape of a map entry", entryName)
+
+			if s.verbose {
+				s.logger.Debug().Str("message", currentPath).Str("field_name", field.GetName()).Msg("detected map field")
+			}
+		}
+
+		s.detectMessageMapEntries(msg.NestedType, currentPath, indices)
+	}
+}
+
+// probeMapKey sends a string and a number as the key of the entry at index, the one that gets rejected reveals the type
+func (s *session) probeMapKey(entry *descriptorpb.DescriptorProto, index []int) *descriptorpb.FieldDescriptorProto {
+	for _, candidate := range []interface{}{"x1", 1} {
+		violations, err := s.probeAPI(genValuePayload(index, []interface{}{candidate}))
+		if err != nil {
+			s.logger.Error().Err(err).Str("message", entry.GetName()).Msg("error when probing map key")
+			return nil
+		}
+
+		for _, violation := range violations {
+			if fieldName := violationField(violation.Field); fieldName != "key" && fieldName != "1" {
+				continue
+			}
+
+			matches := fieldDescRe.FindStringSubmatch(violation.Description)
+			if len(matches) < 3 || typeMap[matches[2]] == nil {
+				continue
+			}
+
+			key := &descriptorpb.FieldDescriptorProto{
+				Name:     proto.String("key"),
+				Number:   proto.Int32(1),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     typeMap[matches[2]],
+				JsonName: proto.String("key"),
+			}
+			entry.Field = append(entry.Field, key)
+			s.noteFieldDiscovery(key, "discovered at index %v while probing the map key: %s", index, violation.Description)
+			return key
+		}
+	}
+	return nil
+}
+
+func findFieldByNumber(msg *descriptorpb.DescriptorProto, number int32) *descriptorpb.FieldDescriptorProto {
+	for _, field := range msg.Field {
+		if field.GetNumber() == number {
+			return field
+		}
+	}
+	return nil
+}
+
+// isValidMapKey reports whether the field type can be used as a map key, which is any integral or string type
+func isValidMapKey(key *descriptorpb.FieldDescriptorProto) bool {
+	switch key.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, descriptorpb.FieldDescriptorProto_TYPE_FLOAT,
+		descriptorpb.FieldDescriptorProto_TYPE_BYTES, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE,
+		descriptorpb.FieldDescriptorProto_TYPE_ENUM, descriptorpb.FieldDescriptorProto_TYPE_GROUP:
+		return false
+	}
+	return true
+}
+
+// mapEntryName returns the name protoc gives the entry message of a map field, ex. "labels_by_id" -> "LabelsByIdEntry"
+func mapEntryName(fieldName string) string {
+	var sb strings.Builder
+	upperNext := true
+	for _, r := range fieldName {
+		if r == '_' {
+			upperNext = true
+			continue
+		}
+		if upperNext {
+			r = unicode.ToUpper(r)
+			upperNext = false
+		}
+		sb.WriteRune(r)
+	}
+	sb.WriteString("Entry")
+	return sb.String()
+}
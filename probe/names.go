@@ -0,0 +1,347 @@
+package probe
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// commonFieldNames are tried as the names of the fields a server only reports by number with WithFieldNameDictionary,
+// after the names discovered so far
+var commonFieldNames = []string{
+	"name", "id", "parent", "title", "display_name", "description", "type", "kind", "value", "key", "state", "status",
+	"etag", "version", "labels", "metadata", "data", "content", "text", "query", "filter", "order_by", "page_size",
+	"page_token", "update_mask", "request_id", "validate_only", "language_code", "region_code", "time_zone",
+	"create_time", "update_time", "start_time", "end_time", "count", "size", "limit", "offset", "enabled", "options",
+	"context", "settings", "config", "items", "tags", "score", "url", "uri", "email", "locale", "target", "source",
+	"mode", "format", "token",
+}
+
+// unnamedFieldName is the name of a field the server only reports by number
+func unnamedFieldName(number int32) string {
+	return fmt.Sprintf("field_%d", number)
+}
+
+func isUnnamedField(field *descriptorpb.FieldDescriptorProto) bool {
+	return field.GetName() == unnamedFieldName(field.GetNumber())
+}
+
+// isGroupField tells whether the field is a proto2 group, which is named after its type, so it keeps its name
+func isGroupField(field *descriptorpb.FieldDescriptorProto) bool {
+	return field.GetType() == descriptorpb.FieldDescriptorProto_TYPE_GROUP
+}
+
+// jsonName returns the JSON name protoc gives a field by default, ex. primary_tag gives primaryTag
+func jsonName(name string) string {
+	var result strings.Builder
+	upper := false
+	for _, r := range name {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		result.WriteRune(r)
+	}
+	return result.String()
+}
+
+// violationField returns the last element of the path of a violation, without the index of a list element. It's the
+// field name, or its number for the servers that only report numbers.
+func violationField(path string) string {
+	z := strings.Split(path, ".")
+	fieldName, _, _ := strings.Cut(z[len(z)-1], "[")
+	return fieldName
+}
+
+// isViolationOf tells whether the violation at path is about field, by name or by number
+func isViolationOf(path string, field *descriptorpb.FieldDescriptorProto) bool {
+	fieldName := violationField(path)
+	return fieldName == field.GetName() || fieldName == strconv.Itoa(int(field.GetNumber()))
+}
+
+// recoverFieldNames correlates the positions of JSPB with the names of JSON payloads. JSON payloads name the fields,
+// so the name of a field only reported by number is found by sending candidate names as JSON, and looking at the
+// number the server reports for the one it doesn't reject as unknown. Setting every named field to an invalid value
+// as JSON gives the JSON name the server reports for each of them.
+//
+// Messages are recovered from the root down, as the JSON payload of a message names the fields leading to it.
+func (s *session) recoverFieldNames() {
+	messages, _ := collectSchema(s.packageFiles())
+	indices := messageIndices(s.rootMessage, messages)
+
+	messageNames := make([]string, 0, len(indices))
+	for name := range indices {
+		messageNames = append(messageNames, name)
+	}
+	sort.Slice(messageNames, func(i, j int) bool {
+		a, b := messageNames[i], messageNames[j]
+		if len(indices[a]) != len(indices[b]) {
+			return len(indices[a]) < len(indices[b])
+		}
+		return a < b
+	})
+
+	candidates := nameCandidates(messages, s.nameDictionary)
+	for _, name := range messageNames {
+		msg, ok := messages[name]
+		if !ok || msg.GetOptions().GetMapEntry() {
+			continue
+		}
+
+		path, ok := fieldPath(messages, s.rootMessage, indices[name])
+		if !ok {
+			if s.verbose {
+				s.logger.Debug().Str("message", name).Msg("skipped name recovery, as a field leading to the message is unnamed")
+			}
+			continue
+		}
+
+		if err := s.recoverUnnamedFields(name, msg, path, candidates); err != nil {
+			s.logger.Error().Err(err).Str("message", name).Msg("error when recovering field names")
+			continue
+		}
+		if err := s.recoverJSONNames(name, msg, path); err != nil {
+			s.logger.Error().Err(err).Str("message", name).Msg("error when recovering JSON names")
+		}
+	}
+}
+
+// recoverUnnamedFields tries every candidate name on the unnamed fields of the message at path
+func (s *session) recoverUnnamedFields(messageName string, msg *descriptorpb.DescriptorProto, path []*descriptorpb.FieldDescriptorProto, candidates []nameCandidate) error {
+	unnamed := 0
+	used := make(map[string]bool, len(msg.Field))
+	for _, field := range msg.Field {
+		if isUnnamedField(field) && !isGroupField(field) {
+			unnamed++
+		}
+		used[field.GetName()] = true
+	}
+
+	for _, candidate := range candidates {
+		if unnamed == 0 {
+			break
+		}
+		if used[candidate.Name] {
+			continue
+		}
+
+		number, ok, err := s.probeFieldName(path, candidate.Name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		field := findFieldByNumber(msg, number)
+		if field == nil || !isUnnamedField(field) || isGroupField(field) {
+			continue
+		}
+		field.Name, field.JsonName = proto.String(candidate.Name), proto.String(jsonName(candidate.Name))
+		used[candidate.Name] = true
+		unnamed--
+		s.noteFieldDiscovery(field, "named %s, a guess from %s that the server reported position %d for in a JSON payload", candidate.Name, candidate.Source, number)
+
+		if s.verbose {
+			s.logger.Debug().Str("message", messageName).Str("field_name", candidate.Name).Str("source", candidate.Source).Int32("number", number).Msg("recovered field name")
+		}
+	}
+
+	if unnamed > 0 {
+		s.logger.Warn().Str("message", messageName).Int("fields", unnamed).Msg("some fields are still only known by number")
+	}
+	return nil
+}
+
+// probeFieldName sends the candidate as JSON with a value that isn't valid for most types, and then with one that is
+// invalid for the rest. A field the server knows gets a violation, with its number if the server reports numbers.
+func (s *session) probeFieldName(path []*descriptorpb.FieldDescriptorProto, candidate string) (int32, bool, error) {
+	for _, value := range []interface{}{true, "x"} {
+		violations, err := s.probeJSON(jsonPayload(path, map[string]interface{}{candidate: value}))
+		if err != nil {
+			return 0, false, err
+		}
+
+		for _, violation := range violations {
+			if strings.Contains(violation.Description, "Unknown name") {
+				return 0, false, nil
+			}
+			// ex. required fields of a message missing around the value
+			if !strings.HasPrefix(violation.Description, "Invalid value at") {
+				continue
+			}
+
+			fieldName := violationField(violation.Field)
+			if !digitsRe.MatchString(fieldName) {
+				// the server names the field, so the number can't be told this way
+				return 0, false, nil
+			}
+			number, err := strconv.Atoi(fieldName)
+			if err != nil {
+				return 0, false, nil
+			}
+			return int32(number), true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// recoverJSONNames sets every named field of the message at path to a value that is invalid for its type, the
+// number of the field, like the crawl does. The path of each violation holds the JSON name the server uses.
+func (s *session) recoverJSONNames(messageName string, msg *descriptorpb.DescriptorProto, path []*descriptorpb.FieldDescriptorProto) error {
+	object := make(map[string]interface{})
+	for _, field := range msg.Field {
+		if isUnnamedField(field) {
+			continue
+		}
+		switch field.GetType() {
+		case descriptorpb.FieldDescriptorProto_TYPE_STRING, descriptorpb.FieldDescriptorProto_TYPE_BYTES, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
+			object[field.GetName()] = field.GetNumber()
+		default:
+			object[field.GetName()] = fmt.Sprintf("x%d", field.GetNumber())
+		}
+	}
+	if len(object) == 0 {
+		return nil
+	}
+
+	violations, err := s.probeJSON(jsonPayload(path, object))
+	if err != nil {
+		return err
+	}
+
+	for _, violation := range violations {
+		if strings.Contains(violation.Description, "Unknown name") {
+			s.logger.Warn().Str("message", messageName).Str("description", violation.Description).Msg("the server doesn't know a field by its name")
+			return nil
+		}
+
+		matches := fieldDescRe.FindStringSubmatch(violation.Description)
+		if len(matches) < 4 {
+			continue
+		}
+		number, err := strconv.Atoi(matches[3])
+		if err != nil {
+			continue
+		}
+		field := findFieldByNumber(msg, int32(number))
+		if field == nil || isUnnamedField(field) {
+			continue
+		}
+
+		reported := violationField(violation.Field)
+		if digitsRe.MatchString(reported) || reported == field.GetName() || reported == field.GetJsonName() {
+			continue
+		}
+		field.JsonName = proto.String(reported)
+		s.noteFieldDiscovery(field, "JSON name %s, as reported for a JSON payload: %s", reported, violation.Description)
+
+		if s.verbose {
+			s.logger.Debug().Str("message", messageName).Str("field_name", field.GetName()).Str("json_name", reported).Msg("recovered JSON name")
+		}
+	}
+	return nil
+}
+
+// fieldPath returns the fields leading from the root message to the message at index (see messageIndices), which
+// have to be named to be sent as JSON
+func fieldPath(messages map[string]*descriptorpb.DescriptorProto, rootMessage string, index []int) ([]*descriptorpb.FieldDescriptorProto, bool) {
+	var path []*descriptorpb.FieldDescriptorProto
+	msg := messages[rootMessage]
+	for i := 0; i < len(index); i++ {
+		if msg == nil {
+			return nil, false
+		}
+		field := findFieldByNumber(msg, int32(index[i]))
+		if field == nil || isUnnamedField(field) {
+			return nil, false
+		}
+		path = append(path, field)
+
+		// the index of the element in the list
+		if field.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+			i++
+		}
+		msg = messages[strings.TrimPrefix(field.GetTypeName(), ".")]
+	}
+	return path, true
+}
+
+// jsonPayload nests object in the fields of path, repeated ones as the first element of a list
+func jsonPayload(path []*descriptorpb.FieldDescriptorProto, object map[string]interface{}) []byte {
+	var result interface{} = object
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i].GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+			result = []interface{}{result}
+		}
+		result = map[string]interface{}{path[i].GetName(): result}
+	}
+
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return nil
+	}
+	return payload
+}
+
+// nameCandidate is a name to try on the unnamed fields, Source tells where the guess comes from
+type nameCandidate struct {
+	Name   string
+	Source string
+}
+
+// nameCandidates returns the names discovered so far, the names the types of the fields suggest (ex. primary_tag for
+// PrimaryTag) and, with dictionary, the common names, once each
+func nameCandidates(messages map[string]*descriptorpb.DescriptorProto, dictionary bool) []nameCandidate {
+	seen := make(map[string]bool)
+	var candidates []nameCandidate
+	add := func(name string, source string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			candidates = append(candidates, nameCandidate{Name: name, Source: source})
+		}
+	}
+
+	messageNames := make([]string, 0, len(messages))
+	for name := range messages {
+		messageNames = append(messageNames, name)
+	}
+	sort.Strings(messageNames)
+
+	var typeNames []nameCandidate
+	for _, name := range messageNames {
+		for _, field := range messages[name].Field {
+			if !isUnnamedField(field) {
+				add(field.GetName(), "the field of "+name)
+			}
+			if typeName := field.GetTypeName(); typeName != "" {
+				z := strings.Split(typeName, ".")
+				source := "the type " + strings.TrimPrefix(typeName, ".")
+				typeNames = append(typeNames, nameCandidate{Name: snakeCase(z[len(z)-1]), Source: source})
+				// a map field is named after its entry, ex. QuotasEntry for quotas
+				if entry, ok := strings.CutSuffix(z[len(z)-1], "Entry"); ok && entry != "" {
+					typeNames = append(typeNames, nameCandidate{Name: snakeCase(entry), Source: source})
+				}
+			}
+		}
+	}
+	for _, candidate := range typeNames {
+		add(candidate.Name, candidate.Source)
+	}
+	if dictionary {
+		for _, name := range commonFieldNames {
+			add(name, "the common field names")
+		}
+	}
+	return candidates
+}
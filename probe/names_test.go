@@ -0,0 +1,169 @@
+package probe
+
+import (
+	"context"
+	"req2proto/gapitest"
+	"req2proto/parser"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestProbeRecoversFieldNames(t *testing.T) {
+	probe := func(opts ...Option) *descriptorpb.FileDescriptorSet {
+		server, err := gapitest.NewServer(loadTestSchema(t), testRequest)
+		if err != nil {
+			t.Fatalf("unable to create fake server: %v", err)
+		}
+		server.PositionalPaths = true
+
+		p := New(append([]Option{WithTransport(NewHandlerTransport(server)), WithRetries(0), WithFieldNames(), WithSourceInfo()}, opts...)...)
+		set, err := p.Probe(context.Background(), testURL, testRequest)
+		if err != nil {
+			t.Fatalf("probe failed: %v", err)
+		}
+		return set
+	}
+
+	// nothing is named in the violations, so only the names suggested by the types are tried
+	set := probe()
+	diffDescriptions(t, map[string][]string{
+		testRequest: {
+			"optional string field_1 = 1",
+			"optional int32 field_2 = 2",
+			"optional int64 field_3 = 3",
+			"optional uint64 field_4 = 4",
+			"optional double field_5 = 5",
+			"optional float field_6 = 6",
+			"optional bool field_7 = 7",
+			"optional bytes field_8 = 8",
+			"optional google.internal.test.v1.Item item = 9",
+			"optional google.internal.test.common.Metadata metadata = 11",
+			"optional google.protobuf.Timestamp field_13 = 13",
+			"optional google.internal.test.v1.CreateItemRequest.Visibility visibility = 15",
+			"repeated string field_16 = 16",
+			"repeated int32 field_17 = 17",
+			"repeated google.internal.test.v1.CreateItemRequest.Visibility field_18 = 18",
+			"repeated google.internal.test.v1.Item field_19 = 19",
+			"repeated google.internal.test.v1.CreateItemRequest.QuotasEntry quotas = 20",
+		},
+		testRequest + ".QuotasEntry": {
+			"optional string key = 1",
+			"optional int64 value = 2",
+		},
+		"google.internal.test.v1.Item": {
+			"optional string field_1 = 1",
+			"optional google.internal.test.v1.Item.Tag field_2 = 2",
+			"optional string field_3 = 3",
+			"optional int64 field_4 = 4",
+		},
+		"google.internal.test.v1.Item.Tag": {
+			"optional string field_1 = 1",
+			"optional fixed32 field_2 = 2",
+		},
+		"google.internal.test.common.Metadata": {
+			"optional string field_1 = 1",
+			"optional sint64 field_2 = 2",
+		},
+	}, describeSchema(set.File, testRequest))
+
+	// the note tells the name is a guess, and where it comes from
+	noted := false
+	for _, file := range set.File {
+		if file.GetName() != FileName("google.internal.test.v1") {
+			continue
+		}
+		for _, loc := range file.GetSourceCodeInfo().GetLocation() {
+			if len(loc.Path) == 4 && fieldAtPath(file, loc.Path).GetName() == "item" {
+				noted = true
+				if want := "named item, a guess from the type google.internal.test.v1.Item"; !strings.Contains(loc.GetLeadingComments(), want) {
+					t.Errorf("item: want the note %q, got %q", want, loc.GetLeadingComments())
+				}
+			}
+		}
+	}
+	if !noted {
+		t.Errorf("item has no discovery note")
+	}
+
+	// names that aren't discovered, suggested by a type or common stay numbered, and so do the fields of a message
+	// reached through one of those
+	diffDescriptions(t, map[string][]string{
+		testRequest: {
+			"optional string name = 1",
+			"optional int32 count = 2",
+			"optional int64 size = 3",
+			"optional uint64 id = 4",
+			"optional double score = 5",
+			"optional float field_6 = 6",
+			"optional bool enabled = 7",
+			"optional bytes data = 8",
+			"optional google.internal.test.v1.Item item = 9",
+			"optional google.internal.test.common.Metadata metadata = 11",
+			"optional google.protobuf.Timestamp create_time = 13",
+			"optional google.internal.test.v1.CreateItemRequest.Visibility visibility = 15",
+			"repeated string tags = 16",
+			"repeated int32 field_17 = 17",
+			"repeated google.internal.test.v1.CreateItemRequest.Visibility field_18 = 18",
+			"repeated google.internal.test.v1.Item items = 19",
+			"repeated google.internal.test.v1.CreateItemRequest.QuotasEntry quotas = 20",
+		},
+		testRequest + ".QuotasEntry": {
+			"optional string key = 1",
+			"optional int64 value = 2",
+		},
+		"google.internal.test.v1.Item": {
+			"optional string title = 1",
+			"optional google.internal.test.v1.Item.Tag field_2 = 2",
+			"optional string text = 3",
+			"optional int64 field_4 = 4",
+		},
+		"google.internal.test.v1.Item.Tag": {
+			"optional string field_1 = 1",
+			"optional fixed32 field_2 = 2",
+		},
+		"google.internal.test.common.Metadata": {
+			"optional string etag = 1",
+			"optional sint64 version = 2",
+		},
+	}, describeSchema(probe(WithFieldNameDictionary()).File, testRequest))
+}
+
+func TestProbeRecoversJSONNames(t *testing.T) {
+	set, err := probeTestSchema(t, context.Background(), WithFieldNames())
+	if err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	diffDescriptions(t, describeSchema(loadTestSchema(t).File, testRequest), describeSchema(set.File, testRequest))
+
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		t.Fatalf("output doesn't resolve: %v", err)
+	}
+	fd, err := files.FindFileByPath(FileName("google.internal.test.v1"))
+	if err != nil {
+		t.Fatalf("no file for the package: %v", err)
+	}
+
+	request := fd.Messages().ByName("CreateItemRequest")
+	for name, want := range map[string]string{"ratio": "aspectRatio", "create_time": "createTime", "name": "name"} {
+		field := request.Fields().ByTextName(name)
+		if field == nil {
+			t.Errorf("field %s is missing", name)
+			continue
+		}
+		if got := field.JSONName(); got != want {
+			t.Errorf("json name of %s: want %s, got %s", name, want, got)
+		}
+	}
+
+	generated := parser.GenerateProtoFile(fd)
+	if !strings.Contains(generated, `float ratio = 6 [json_name = "aspectRatio"]`) {
+		t.Errorf("generated file doesn't spell out the json name of ratio:\n%s", generated)
+	}
+	if strings.Contains(generated, `json_name = "createTime"`) {
+		t.Errorf("generated file spells out a default json name:\n%s", generated)
+	}
+}
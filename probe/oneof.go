@@ -0,0 +1,178 @@
+package probe
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// the oneof is named first, then the field that can't be set along with the one set before. ESF words it as
+// "Invalid value at 'query_input' (oneof), oneof field 'input' is already set. Cannot set 'text'", the JSON parser
+// of other frontends starts with "Oneof field", and the quoting and punctuation vary.
+var oneofAlreadySetRe = regexp.MustCompile(`(?i)\boneof field\s+["'\x60]?([A-Za-z0-9_.]+)["'\x60]?\s+is already set\s*[.,;:]?\s*cannot set\s+["'\x60]?([A-Za-z0-9_.]+)`)
+
+// detectOneofs sets every pair of fields of each message, and groups the fields the server reports as mutually exclusive
+// into the oneof it names. Pairs that are already known to be in the same oneof are skipped.
+func (s *session) detectOneofs() {
+	messages, enums := collectSchema(s.packageFiles())
+	indices := messageIndices(s.rootMessage, messages)
+
+	messageNames := make([]string, 0, len(indices))
+	for name := range indices {
+		messageNames = append(messageNames, name)
+	}
+	sort.Strings(messageNames)
+
+	for _, name := range messageNames {
+		msg, ok := messages[name]
+		if !ok || msg.GetOptions().GetMapEntry() {
+			continue
+		}
+
+		var candidates []*descriptorpb.FieldDescriptorProto
+		values := make(map[*descriptorpb.FieldDescriptorProto]interface{})
+		for _, field := range msg.Field {
+			if field.GetLabel() != descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL || field.OneofIndex != nil {
+				continue
+			}
+			if value, ok := oneofProbeValue(field, enums); ok {
+				candidates = append(candidates, field)
+				values[field] = value
+			}
+		}
+		if len(candidates) < 2 {
+			continue
+		}
+
+		// the server names the oneof of the field it refuses to set, and the other field of the pair was set
+		// first, so both are members of it
+		oneofOf := make(map[*descriptorpb.FieldDescriptorProto]string, len(candidates))
+		for i := 0; i < len(candidates); i++ {
+			for j := i + 1; j < len(candidates); j++ {
+				a, b := candidates[i], candidates[j]
+				if oneofOf[a] != "" && oneofOf[a] == oneofOf[b] {
+					continue
+				}
+
+				fields := make([]interface{}, max(a.GetNumber(), b.GetNumber()))
+				fields[a.GetNumber()-1] = values[a]
+				fields[b.GetNumber()-1] = values[b]
+
+				violations, err := s.probeAPI(genValuePayload(indices[name], fields))
+				if err != nil {
+					s.logger.Error().Err(err).Str("message", name).Msg("error when probing oneofs")
+					continue
+				}
+
+				for _, violation := range violations {
+					if oneofName, _, ok := parseOneofAlreadySet(violation.Description); ok {
+						oneofOf[a], oneofOf[b] = oneofName, oneofName
+						break
+					}
+				}
+			}
+		}
+
+		groups := make(map[string][]*descriptorpb.FieldDescriptorProto)
+		var oneofNames []string
+		for _, field := range candidates {
+			oneofName := oneofOf[field]
+			if oneofName == "" {
+				continue
+			}
+			if _, ok := groups[oneofName]; !ok {
+				oneofNames = append(oneofNames, oneofName)
+			}
+			groups[oneofName] = append(groups[oneofName], field)
+		}
+
+		// in the order of their first field
+		sort.SliceStable(oneofNames, func(i, j int) bool {
+			return minFieldNumber(groups[oneofNames[i]]) < minFieldNumber(groups[oneofNames[j]])
+		})
+
+		for _, oneofName := range oneofNames {
+			s.applyOneof(msg, oneofName, groups[oneofName])
+
+			if s.verbose {
+				s.logger.Debug().Str("message", name).Str("oneof", oneofName).Int("fields", len(groups[oneofName])).Msg("detected oneof")
+			}
+		}
+	}
+}
+
+// parseOneofAlreadySet returns the oneof and the field of a violation for setting a second member of a oneof. Names
+// may be qualified by the path of the message, only the last part is kept.
+func parseOneofAlreadySet(description string) (string, string, bool) {
+	matches := oneofAlreadySetRe.FindStringSubmatch(description)
+	if matches == nil {
+		return "", "", false
+	}
+	oneofName := matches[1][strings.LastIndex(matches[1], ".")+1:]
+	fieldName := matches[2][strings.LastIndex(matches[2], ".")+1:]
+	return oneofName, fieldName, oneofName != "" && fieldName != ""
+}
+
+func minFieldNumber(fields []*descriptorpb.FieldDescriptorProto) int32 {
+	number := fields[0].GetNumber()
+	for _, field := range fields[1:] {
+		number = min(number, field.GetNumber())
+	}
+	return number
+}
+
+// oneofProbeValue returns a value that sets the field without being rejected for its type
+func oneofProbeValue(field *descriptorpb.FieldDescriptorProto, enums map[string]*descriptorpb.EnumDescriptorProto) (interface{}, bool) {
+	switch field.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_STRING:
+		return "x1", true
+	case descriptorpb.FieldDescriptorProto_TYPE_BYTES:
+		return "eDE=", true
+	case descriptorpb.FieldDescriptorProto_TYPE_BOOL:
+		return true, true
+	case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE:
+		return []interface{}{}, true
+	case descriptorpb.FieldDescriptorProto_TYPE_ENUM:
+		enum, ok := enums[field.GetTypeName()[1:]]
+		if !ok || len(enum.Value) == 0 {
+			return 0, true
+		}
+		return enum.Value[0].GetNumber(), true
+	case descriptorpb.FieldDescriptorProto_TYPE_GROUP:
+		return nil, false
+	}
+	return 1, true
+}
+
+// applyOneof declares the oneof the server named for the fields, and moves the fields next to each other as the
+// fields of a oneof have to be declared together
+func (s *session) applyOneof(msg *descriptorpb.DescriptorProto, oneofName string, fields []*descriptorpb.FieldDescriptorProto) {
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].GetNumber() < fields[j].GetNumber()
+	})
+
+	oneofIndex := int32(len(msg.OneofDecl))
+	msg.OneofDecl = append(msg.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: proto.String(oneofName)})
+
+	members := make(map[*descriptorpb.FieldDescriptorProto]bool, len(fields))
+	for _, field := range fields {
+		field.OneofIndex = proto.Int32(oneofIndex)
+		members[field] = true
+		s.noteFieldDiscovery(field, "part of %s, as the server rejected setting it along with another field of the oneof", oneofName)
+	}
+
+	reordered := make([]*descriptorpb.FieldDescriptorProto, 0, len(msg.Field))
+	added := false
+	for _, field := range msg.Field {
+		if !members[field] {
+			reordered = append(reordered, field)
+		} else if !added {
+			reordered = append(reordered, fields...)
+			added = true
+		}
+	}
+	msg.Field = reordered
+}
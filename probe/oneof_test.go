@@ -0,0 +1,28 @@
+package probe
+
+import "testing"
+
+func TestParseOneofAlreadySet(t *testing.T) {
+	tests := []struct {
+		description string
+		oneof       string
+		field       string
+		ok          bool
+	}{
+		// ESF, ex. Dialogflow's detectIntent with both text and event in query_input
+		{"Invalid value at 'query_input' (oneof), oneof field 'input' is already set. Cannot set 'event'", "input", "event", true},
+		// the JSON parser, before the payload is mapped to a field
+		{"Invalid JSON payload received. Oneof field 'input' is already set. Cannot set 'text'", "input", "text", true},
+		{`oneof field "content" is already set, cannot set "number"`, "content", "number", true},
+		{"Oneof field `content` is already set; Cannot set `request.item.text`", "content", "text", true},
+		{"Invalid value at 'text' (TYPE_STRING), 1", "", "", false},
+		{"Invalid JSON payload received. Unknown name \"oneof\": Cannot find field.", "", "", false},
+	}
+
+	for _, tt := range tests {
+		oneof, field, ok := parseOneofAlreadySet(tt.description)
+		if oneof != tt.oneof || field != tt.field || ok != tt.ok {
+			t.Errorf("%q: want %q, %q, %v, got %q, %q, %v", tt.description, tt.oneof, tt.field, tt.ok, oneof, field, ok)
+		}
+	}
+}
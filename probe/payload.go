@@ -0,0 +1,128 @@
+package probe
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const (
+	size = 300
+)
+
+func genPayload(indices []int, dataType string) []byte {
+	var result interface{}
+
+	switch dataType {
+	case "int":
+		result = generateIntSlice(size)
+	case "str":
+		result = generateStrSlice(size)
+	case "bool":
+		result = generateBoolSlice(size)
+	case "int-list":
+		result = generateListSlice(size, false)
+	case "str-list":
+		result = generateListSlice(size, true)
+	default:
+		return nil
+	}
+
+	return genValuePayload(indices, result)
+}
+
+// genValuePayload nests value in arrays so that it ends up at the given indices (field numbers)
+func genValuePayload(indices []int, value interface{}) []byte {
+	result := value
+	for i := len(indices) - 1; i >= 0; i-- {
+		index := indices[i]
+		newSlice := make([]interface{}, index)
+		for j := 0; j < index-1; j++ {
+			newSlice[j] = nil
+		}
+		newSlice[index-1] = result
+		result = newSlice
+	}
+
+	payload, err := json.Marshal(result)
+	if err != nil {
+		return nil
+	}
+
+	return payload
+}
+
+// messageIndices returns the indices to send a payload for each message reachable from the root message at, by full name.
+// A message reachable through several fields gets the index of the first one found, going through the fields breadth-first.
+func messageIndices(rootMessage string, messages map[string]*descriptorpb.DescriptorProto) map[string][]int {
+	indices := map[string][]int{rootMessage: {}}
+	queue := []string{rootMessage}
+
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+
+		msg, ok := messages[name]
+		if !ok {
+			continue
+		}
+
+		for _, field := range msg.Field {
+			if field.GetType() != descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+				continue
+			}
+			typeName := field.GetTypeName()[1:]
+			if _, ok := indices[typeName]; ok {
+				continue
+			}
+
+			index := append(append([]int(nil), indices[name]...), int(field.GetNumber()))
+			// the first element of a list
+			if field.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+				index = append(index, 1)
+			}
+			indices[typeName] = index
+			queue = append(queue, typeName)
+		}
+	}
+
+	return indices
+}
+
+func generateIntSlice(n int) []int {
+	slice := make([]int, n)
+	for i := 0; i < n; i++ {
+		slice[i] = i + 1
+	}
+	return slice
+}
+
+func generateStrSlice(n int) []string {
+	slice := make([]string, n)
+	for i := 0; i < n; i++ {
+		slice[i] = fmt.Sprintf("x%d", i+1)
+	}
+	return slice
+}
+
+func generateBoolSlice(n int) []bool {
+	slice := make([]bool, n)
+	for i := 0; i < n; i++ {
+		slice[i] = i%2 == 1
+	}
+	return slice
+}
+
+// generateListSlice puts every value of an int (or str) slice in a list of its own, which only repeated fields accept
+func generateListSlice(n int, str bool) [][]interface{} {
+	slice := make([][]interface{}, n)
+	for i := 0; i < n; i++ {
+		if str {
+			slice[i] = []interface{}{fmt.Sprintf("x%d", i+1)}
+		} else {
+			slice[i] = []interface{}{i + 1}
+		}
+	}
+	return slice
+}
@@ -0,0 +1,107 @@
+package probe
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type FieldViolation struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+type ErrorResponse struct {
+	Error struct {
+		Details []struct {
+			Type            string            `json:"@type"`
+			FieldViolations []FieldViolation  `json:"fieldViolations"`
+			Metadata        map[string]string `json:"metadata"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+// send posts the payload as JSPB
+func (s *session) send(payload []byte) (*Response, error) {
+	return s.sendTo(s.url, payload)
+}
+
+// sendTo posts the payload as JSPB to another URL than the one being probed, ex. with another alt parameter
+func (s *session) sendTo(url string, payload []byte) (*Response, error) {
+	return s.post(url, "application/json+protobuf", payload)
+}
+
+// sendJSON posts the payload as JSON, where fields are named instead of laid out by number
+func (s *session) sendJSON(payload []byte) (*Response, error) {
+	return s.post(s.url, "application/json", payload)
+}
+
+func (s *session) post(url string, contentType string, payload []byte) (*Response, error) {
+	header := make(http.Header, len(s.headers)+1)
+	for k, v := range s.headers {
+		header.Set(k, v)
+	}
+	header.Set("Content-Type", contentType)
+
+	return s.client.do(s.ctx, &Request{Method: s.method, URL: url, Header: header, Body: payload})
+}
+
+func (s *session) testAPI(payload []byte) (*Response, error) {
+	return s.send(payload)
+}
+
+func (s *session) probeAPI(payload []byte) ([]FieldViolation, error) {
+	resp, err := s.send(payload)
+	if err != nil {
+		return nil, err
+	}
+	return parseViolations(resp)
+}
+
+// probeJSON is probeAPI with a JSON payload
+func (s *session) probeJSON(payload []byte) ([]FieldViolation, error) {
+	resp, err := s.sendJSON(payload)
+	if err != nil {
+		return nil, err
+	}
+	return parseViolations(resp)
+}
+
+// parseViolations returns the field violations of an error response, in any of the encodings a server answers with
+func parseViolations(resp *Response) ([]FieldViolation, error) {
+	var err error
+	var violations []FieldViolation
+	contentType := resp.Header.Get("Content-Type")
+
+	// Content-Type: application/json+protobuf (protojson)
+	if strings.Contains(contentType, "application/json+protobuf") {
+		violations, err = parseJSPBErrorResponse(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+
+	} else if strings.Contains(contentType, "application/json") {
+		var response ErrorResponse
+		err = json.Unmarshal(resp.Body, &response)
+		if err != nil {
+			return nil, err
+		}
+
+		// the BadRequest isn't always the first detail, ex. when there's an ErrorInfo as well
+		for _, detail := range response.Error.Details {
+			violations = append(violations, detail.FieldViolations...)
+		}
+	} else if strings.Contains(contentType, "application/x-protobuf") {
+		violations, err = parseProtoErrorResponse(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+
+	} else {
+		return nil, fmt.Errorf("%s parsing has not been implemented yet, try -a json", contentType)
+	}
+
+	return violations, nil
+
+}
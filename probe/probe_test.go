@@ -0,0 +1,386 @@
+package probe
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"req2proto/gapitest"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const (
+	testURL     = "https://test-pa.googleapis.com/v1/items:create"
+	testRequest = "google.internal.test.v1.CreateItemRequest"
+)
+
+// loadTestSchema loads testdata/schema, which the fake server validates payloads against
+func loadTestSchema(t *testing.T) *descriptorpb.FileDescriptorSet {
+	t.Helper()
+
+	files, err := LoadDescriptorPath("testdata/schema")
+	if err != nil {
+		t.Fatalf("unable to load test schema: %v", err)
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, fd := range files {
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(fd))
+	}
+	return set
+}
+
+// probeTestSchema probes a fake server for the test schema
+func probeTestSchema(t *testing.T, ctx context.Context, opts ...Option) (*descriptorpb.FileDescriptorSet, error) {
+	t.Helper()
+
+	server, err := gapitest.NewServer(loadTestSchema(t), testRequest)
+	if err != nil {
+		t.Fatalf("unable to create fake server: %v", err)
+	}
+
+	p := New(append([]Option{WithTransport(NewHandlerTransport(server)), WithRetries(0)}, opts...)...)
+	return p.Probe(ctx, testURL, testRequest)
+}
+
+// countingTransport counts the requests sent through it
+type countingTransport struct {
+	Transport
+	count atomic.Int64
+}
+
+func (t *countingTransport) Do(ctx context.Context, req *Request) (*Response, error) {
+	t.count.Add(1)
+	return t.Transport.Do(ctx, req)
+}
+
+// countTestSchemaRequests probes the test schema like probeTestSchema, and returns the number of requests sent
+func countTestSchemaRequests(t *testing.T, opts ...Option) (*descriptorpb.FileDescriptorSet, int64) {
+	t.Helper()
+
+	server, err := gapitest.NewServer(loadTestSchema(t), testRequest)
+	if err != nil {
+		t.Fatalf("unable to create fake server: %v", err)
+	}
+
+	transport := &countingTransport{Transport: NewHandlerTransport(server)}
+	p := New(append([]Option{WithTransport(transport), WithRetries(0)}, opts...)...)
+	set, err := p.Probe(context.Background(), testURL, testRequest)
+	if err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	return set, transport.count.Load()
+}
+
+// describeSchema describes the fields of every message reachable from roots outside of google/protobuf, by full
+// message name
+func describeSchema(files []*descriptorpb.FileDescriptorProto, roots ...string) map[string][]string {
+	var own []*descriptorpb.FileDescriptorProto
+	for _, file := range files {
+		if !strings.HasPrefix(file.GetName(), "google/protobuf/") {
+			own = append(own, file)
+		}
+	}
+
+	messages, _ := collectSchema(own)
+	reachable := make(map[string]bool)
+	for _, root := range roots {
+		for name := range messageIndices(root, messages) {
+			reachable[name] = true
+		}
+	}
+
+	result := make(map[string][]string, len(messages))
+	for name, msg := range messages {
+		if !reachable[name] {
+			continue
+		}
+
+		fields := make([]*descriptorpb.FieldDescriptorProto, len(msg.Field))
+		copy(fields, msg.Field)
+		sort.Slice(fields, func(i, j int) bool {
+			return fields[i].GetNumber() < fields[j].GetNumber()
+		})
+
+		result[name] = []string{}
+		for _, field := range fields {
+			result[name] = append(result[name], describeField(field))
+		}
+	}
+	return result
+}
+
+func diffDescriptions(t *testing.T, want map[string][]string, got map[string][]string) {
+	t.Helper()
+
+	for name, fields := range want {
+		if !reflect.DeepEqual(fields, got[name]) {
+			t.Errorf("message %s:\nwant %q\ngot  %q", name, fields, got[name])
+		}
+	}
+	for name, fields := range got {
+		if _, ok := want[name]; !ok {
+			t.Errorf("unexpected message %s: %q", name, fields)
+		}
+	}
+}
+
+func TestProbeRecoversSchema(t *testing.T) {
+	for _, alt := range []string{"auto", "json", "proto"} {
+		t.Run(alt, func(t *testing.T) {
+			set, err := probeTestSchema(t, context.Background(), WithResponseEncoding(alt))
+			if err != nil {
+				t.Fatalf("probe failed: %v", err)
+			}
+
+			diffDescriptions(t, describeSchema(loadTestSchema(t).File, testRequest), describeSchema(set.File, testRequest))
+		})
+	}
+}
+
+func TestProbeOutputIsSelfContained(t *testing.T) {
+	set, err := probeTestSchema(t, context.Background())
+	if err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+
+	var names []string
+	for _, file := range set.File {
+		names = append(names, file.GetName())
+	}
+	want := []string{"google/internal/test/common/message.proto", "google/protobuf/timestamp.proto", "google/internal/test/v1/message.proto"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("files: want %q, got %q", want, names)
+	}
+
+	if _, err := protodesc.NewFiles(set); err != nil {
+		t.Errorf("output doesn't resolve: %v", err)
+	}
+}
+
+func TestProbeConcurrencyIsDeterministic(t *testing.T) {
+	single, err := probeTestSchema(t, context.Background())
+	if err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		concurrent, err := probeTestSchema(t, context.Background(), WithConcurrency(4))
+		if err != nil {
+			t.Fatalf("probe failed: %v", err)
+		}
+		if !proto.Equal(single, concurrent) {
+			t.Fatalf("output with 4 workers differs from a single worker")
+		}
+	}
+}
+
+func TestProbeOneofs(t *testing.T) {
+	set, err := probeTestSchema(t, context.Background(), WithOneofs())
+	if err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+
+	messages, _ := collectSchema(set.File)
+	item := messages["google.internal.test.v1.Item"]
+	if item == nil {
+		t.Fatalf("Item wasn't discovered")
+	}
+
+	var members []string
+	for _, field := range item.Field {
+		if field.OneofIndex != nil {
+			members = append(members, fmt.Sprintf("%s.%s", item.OneofDecl[field.GetOneofIndex()].GetName(), field.GetName()))
+		}
+	}
+	sort.Strings(members)
+	if want := []string{"content.number", "content.text"}; !reflect.DeepEqual(members, want) {
+		t.Errorf("oneof members: want %q, got %q", want, members)
+	}
+}
+
+func TestProbeEnumValues(t *testing.T) {
+	set, err := probeTestSchema(t, context.Background(), WithEnumValues(3))
+	if err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+
+	_, enums := collectSchema(set.File)
+	enum := enums["google.internal.test.v1.CreateItemRequest.Visibility"]
+	if enum == nil {
+		t.Fatalf("Visibility wasn't discovered")
+	}
+
+	// the enum is open, so numbers tell nothing and only the zero value is found
+	var values []string
+	for _, value := range enum.Value {
+		values = append(values, fmt.Sprintf("%s = %d", value.GetName(), value.GetNumber()))
+	}
+	if want := []string{"VISIBILITY_UNSPECIFIED = 0"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("enum values: want %q, got %q", want, values)
+	}
+}
+
+func TestProbeClosedEnumValues(t *testing.T) {
+	const requestType = "google.internal.test.legacy.SearchRequest"
+	server, err := gapitest.NewServer(loadTestSchema(t), requestType)
+	if err != nil {
+		t.Fatalf("unable to create fake server: %v", err)
+	}
+
+	p := New(WithTransport(NewHandlerTransport(server)), WithRetries(0), WithEnumValues(3))
+	set, err := p.Probe(context.Background(), testURL, requestType)
+	if err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+
+	_, enums := collectSchema(set.File)
+	enum := enums["google.internal.test.legacy.Sort"]
+	if enum == nil {
+		t.Fatalf("Sort wasn't discovered")
+	}
+
+	// the enum is closed, so only its numbers are accepted. It's proto2, so it doesn't get a synthetic zero value.
+	var values []string
+	for _, value := range enum.Value {
+		values = append(values, fmt.Sprintf("%s = %d", value.GetName(), value.GetNumber()))
+	}
+	if want := []string{"SORT_VALUE_1 = 1", "SORT_VALUE_2 = 2"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("enum values: want %q, got %q", want, values)
+	}
+}
+
+func TestEnumValueRejected(t *testing.T) {
+	field := &descriptorpb.FieldDescriptorProto{Name: proto.String("field_4"), Number: proto.Int32(4)}
+	leaked := make(map[int32]string)
+
+	// another field's violation looks like a list of values, but isn't about the enum
+	other := []FieldViolation{{Field: "limits", Description: "Invalid value at 'limits', MAX: 100"}}
+	if enumValueRejected(other, field, leaked) || len(leaked) != 0 {
+		t.Errorf("want the violation of another field ignored, got leaked values %v", leaked)
+	}
+
+	// unnamed fields are reported by number
+	violations := append(other, FieldViolation{Field: "4", Description: "Invalid value at 'sort' (type.googleapis.com/google.example.Sort), 3 (valid values: RELEVANCE = 1, DATE: 2)"})
+	if !enumValueRejected(violations, field, leaked) {
+		t.Errorf("want the value rejected")
+	}
+	if want := map[int32]string{1: "RELEVANCE", 2: "DATE"}; !reflect.DeepEqual(leaked, want) {
+		t.Errorf("leaked values: want %v, got %v", want, leaked)
+	}
+}
+
+func TestApplyEnumValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		proto2   bool
+		zeroName string
+		numbers  []int32
+		leaked   map[int32]string
+		want     []string
+	}{
+		{
+			name:     "leaked names",
+			zeroName: "SORT_UNSPECIFIED",
+			numbers:  []int32{1, 2, 3},
+			leaked:   map[int32]string{1: "RELEVANCE", 2: "DATE"},
+			want:     []string{"SORT_UNSPECIFIED = 0", "RELEVANCE = 1", "DATE = 2", "SORT_VALUE_3 = 3"},
+		},
+		{
+			name:    "no zero name",
+			numbers: []int32{2},
+			want:    []string{"UNKNOWN_SORT = 0", "SORT_VALUE_2 = 2"},
+		},
+		{
+			name:    "closed",
+			proto2:  true,
+			numbers: []int32{1, 2},
+			want:    []string{"SORT_VALUE_1 = 1", "SORT_VALUE_2 = 2"},
+		},
+		{
+			// an enum needs a value, even if nothing was accepted
+			name:   "closed without values",
+			proto2: true,
+			want:   []string{"UNKNOWN_SORT = 0"},
+		},
+		{
+			// a leaked name can't be used twice
+			name:   "duplicate leaked name",
+			leaked: map[int32]string{1: "DEFAULT", 2: "DEFAULT"},
+			want:   []string{"UNKNOWN_SORT = 0", "DEFAULT = 1", "DEFAULT_2 = 2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enum := &descriptorpb.EnumDescriptorProto{
+				Name:  proto.String("Sort"),
+				Value: []*descriptorpb.EnumValueDescriptorProto{{Name: proto.String("UNKNOWN_SORT"), Number: proto.Int32(0)}},
+			}
+			applyEnumValues(enum, !tt.proto2, tt.zeroName, tt.numbers, tt.leaked)
+
+			var values []string
+			for _, value := range enum.Value {
+				values = append(values, fmt.Sprintf("%s = %d", value.GetName(), value.GetNumber()))
+			}
+			if !reflect.DeepEqual(values, tt.want) {
+				t.Errorf("enum values: want %q, got %q", tt.want, values)
+			}
+		})
+	}
+}
+
+func TestProbeCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := probeTestSchema(t, ctx); err == nil {
+		t.Errorf("probe with a canceled context succeeded")
+	}
+}
+
+func TestProbeSourceInfo(t *testing.T) {
+	// leading comments of the fields, by message and field name
+	comments := func(set *descriptorpb.FileDescriptorSet) map[string]string {
+		result := make(map[string]string)
+		for _, file := range set.File {
+			if file.GetName() != FileName("google.internal.test.v1") {
+				continue
+			}
+			for _, loc := range file.GetSourceCodeInfo().GetLocation() {
+				if len(loc.Path) != 4 || loc.LeadingComments == nil {
+					continue
+				}
+				msg := file.MessageType[loc.Path[1]]
+				result[msg.GetName()+"."+msg.Field[loc.Path[3]].GetName()] = loc.GetLeadingComments()
+			}
+		}
+		return result
+	}
+
+	set, err := probeTestSchema(t, context.Background(), WithSourceInfo())
+	if err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	got := comments(set)
+	if note := got["CreateItemRequest.name"]; !strings.HasPrefix(note, " discovered at index []: Invalid value at 'name' (TYPE_STRING)") {
+		t.Errorf("name: want the discovery note as leading comment, got %q", note)
+	}
+	if note := got["CreateItemRequest.tags"]; !strings.Contains(note, "label set to repeated") {
+		t.Errorf("tags: want the repeated note as leading comment, got %q", note)
+	}
+
+	set, err = probeTestSchema(t, context.Background())
+	if err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	if got := comments(set); len(got) != 0 {
+		t.Errorf("want no leading comments without WithSourceInfo, got %v", got)
+	}
+}
@@ -0,0 +1,497 @@
+// Package probe reconstructs the protobuf definitions of a Google API endpoint from the field violations it returns
+// for JSPB (application/json+protobuf) payloads.
+package probe
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Prober probes endpoints with the options it was created with. It can be used for several endpoints, even
+// concurrently, as every call to Probe starts from scratch.
+type Prober struct {
+	method             string
+	headers            map[string]string
+	maxDepth           int
+	concurrency        int
+	logger             zerolog.Logger
+	verbose            bool
+	alt                string
+	transport          Transport
+	qps                float64
+	maxInFlight        int
+	retries            int
+	knownPaths         []string
+	importPaths        []string
+	checkpointDir      string
+	checkpointInterval time.Duration
+	resumeDir          string
+	enumValues         int
+	oneofs             bool
+	bytesMessages      bool
+	typeReport         func([]TypeRefinement)
+	sourceInfo         bool
+	services           bool
+	responseBody       []byte
+	fieldNames         bool
+	nameDictionary     bool
+}
+
+// Option configures a Prober
+type Option func(*Prober)
+
+// New creates a Prober. By default it sends POST requests without headers, probes without a depth limit with a
+// single worker, and doesn't log anything.
+func New(opts ...Option) *Prober {
+	p := &Prober{
+		method:             "POST",
+		headers:            make(map[string]string),
+		maxDepth:           -1,
+		concurrency:        1,
+		logger:             zerolog.Nop(),
+		alt:                "auto",
+		retries:            5,
+		checkpointInterval: time.Minute,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// WithMethod sets the HTTP method, POST by default
+func WithMethod(method string) Option {
+	return func(p *Prober) {
+		p.method = method
+	}
+}
+
+// WithHeaders adds headers to every request, ex. for authentication
+func WithHeaders(headers map[string]string) Option {
+	return func(p *Prober) {
+		for k, v := range headers {
+			p.headers[k] = v
+		}
+	}
+}
+
+// WithMaxDepth limits how deep nested messages are probed, -1 (the default) means unlimited
+func WithMaxDepth(depth int) Option {
+	return func(p *Prober) {
+		p.maxDepth = depth
+	}
+}
+
+// WithConcurrency sets the number of concurrent probe workers. The output is the same regardless.
+func WithConcurrency(workers int) Option {
+	return func(p *Prober) {
+		p.concurrency = workers
+	}
+}
+
+// WithLogger sets the logger for progress and errors
+func WithLogger(logger zerolog.Logger) Option {
+	return func(p *Prober) {
+		p.logger = logger
+	}
+}
+
+// WithVerbose logs every discovered field and message at debug level
+func WithVerbose(verbose bool) Option {
+	return func(p *Prober) {
+		p.verbose = verbose
+	}
+}
+
+// WithResponseEncoding sets the alt parameter of the URL: json, proto or auto (the default, keeps the URL as is)
+func WithResponseEncoding(alt string) Option {
+	return func(p *Prober) {
+		p.alt = alt
+	}
+}
+
+// WithTransport sets the Transport requests are sent with, NewFastHTTPTransport(nil) by default
+func WithTransport(transport Transport) Option {
+	return func(p *Prober) {
+		p.transport = transport
+	}
+}
+
+// WithRateLimit limits the requests per second and the requests in flight, 0 means unlimited
+func WithRateLimit(qps float64, maxInFlight int) Option {
+	return func(p *Prober) {
+		p.qps = qps
+		p.maxInFlight = maxInFlight
+	}
+}
+
+// WithRetries sets how many times network errors, 429, 500, 502, 503 and 504 responses are retried, 5 by default
+func WithRetries(retries int) Option {
+	return func(p *Prober) {
+		p.retries = retries
+	}
+}
+
+// WithKnown merges existing .proto files or descriptor sets into the output, their types aren't probed again
+func WithKnown(paths ...string) Option {
+	return func(p *Prober) {
+		p.knownPaths = append(p.knownPaths, paths...)
+	}
+}
+
+// WithImportPaths loads existing .proto files or descriptor sets, whose types are imported instead of probed
+func WithImportPaths(paths ...string) Option {
+	return func(p *Prober) {
+		p.importPaths = append(p.importPaths, paths...)
+	}
+}
+
+// WithCheckpoint periodically saves the probe state to dir, so it can be resumed with WithResume
+func WithCheckpoint(dir string, interval time.Duration) Option {
+	return func(p *Prober) {
+		p.checkpointDir = dir
+		p.checkpointInterval = interval
+	}
+}
+
+// WithResume continues from the checkpoint in dir, the root type passed to Probe is only used for older checkpoints
+func WithResume(dir string) Option {
+	return func(p *Prober) {
+		p.resumeDir = dir
+	}
+}
+
+// WithEnumValues probes enum numbers up to maxValue, as well as common value names, after the crawl
+func WithEnumValues(maxValue int) Option {
+	return func(p *Prober) {
+		p.enumValues = maxValue
+	}
+}
+
+// WithOneofs sets every pair of fields after the crawl to find the fields that are part of a oneof
+func WithOneofs() Option {
+	return func(p *Prober) {
+		p.oneofs = true
+	}
+}
+
+// WithBytesMessages checks whether bytes fields hold serialized messages after the crawl
+func WithBytesMessages() Option {
+	return func(p *Prober) {
+		p.bytesMessages = true
+	}
+}
+
+// WithTypeRefinement sends boundary values to every numeric field after the crawl to check its type. The fields
+// that were changed or are still ambiguous are passed to report.
+func WithTypeRefinement(report func([]TypeRefinement)) Option {
+	return func(p *Prober) {
+		p.typeReport = report
+	}
+}
+
+// WithSourceInfo includes source_code_info with comments on how each field was discovered
+func WithSourceInfo() Option {
+	return func(p *Prober) {
+		p.sourceInfo = true
+	}
+}
+
+// WithServices generates a service with the rpc of the endpoint, named by an ErrorInfo of the server, along with
+// its google.api.http annotation
+func WithServices() Option {
+	return func(p *Prober) {
+		p.services = true
+	}
+}
+
+// WithResponse infers the response message after the crawl, from the reply to body, which has to be a valid JSPB
+// request. The response is named after the rpc method, or after the request (FooRequest gives FooResponse).
+func WithResponse(body []byte) Option {
+	return func(p *Prober) {
+		p.responseBody = body
+	}
+}
+
+// WithFieldNames recovers the names after the crawl by sending the payloads as JSON as well. Fields the server
+// only reports by number are named after it (field_N) until then, and every field gets the JSON name the server uses.
+func WithFieldNames() Option {
+	return func(p *Prober) {
+		p.fieldNames = true
+	}
+}
+
+// WithFieldNameDictionary also tries common field names (name, id, create_time, ...) with WithFieldNames, besides the
+// names discovered so far and the ones suggested by the types. Each name costs up to two requests for every message
+// with unnamed fields, and a name the server knows is only a guess for the field.
+func WithFieldNameDictionary() Option {
+	return func(p *Prober) {
+		p.nameDictionary = true
+	}
+}
+
+// FileName returns the name of the file the messages of a package are generated in
+func FileName(packageName string) string {
+	return strings.Replace(packageName, ".", "/", -1) + "/message.proto"
+}
+
+// ServiceFileName returns the name of the file the services of a package are generated in
+func ServiceFileName(packageName string) string {
+	return strings.Replace(packageName, ".", "/", -1) + "/service.proto"
+}
+
+// session is the state of a single Probe call
+type session struct {
+	*Prober
+
+	ctx         context.Context
+	url         string
+	rootMessage string
+	client      *httpClient
+	checkpoint  *checkpointer
+
+	packageFDProtoMap    map[string]*descriptorpb.FileDescriptorProto
+	packageDependencyMap map[string][]string
+
+	// notes on how each field was discovered, which end up as comments in source_code_info
+	fieldDiscoveryMap map[*descriptorpb.FieldDescriptorProto][]string
+	// findings about a field that are part of the output regardless of WithSourceInfo, they end up as trailing comments
+	fieldAnnotationMap map[*descriptorpb.FieldDescriptorProto]string
+
+	// every message and enum loaded with WithKnown, by full name. They are part of the output, but aren't probed
+	// again when they show up in a violation.
+	knownTypes map[string]knownType
+	// the files loaded with WithImportPaths, their types are imported instead of being probed
+	importedFiles *protoregistry.Files
+	// every discovered enum, once each, to probe the values of after the crawl
+	enumTargets []enumTarget
+	// the fields whose type was guessed, as the violation didn't name it
+	provisionalFields map[*descriptorpb.FieldDescriptorProto]bool
+	// the gRPC method of the endpoint, if an ErrorInfo names it
+	rpcMethod string
+	// the files with services, by package. In a batch they hold the rpcs of the endpoints before this one.
+	serviceFDProtoMap map[string]*descriptorpb.FileDescriptorProto
+}
+
+// Probe discovers the request message of the endpoint at url, whose full name is rootType (ex.
+// google.internal.people.v2.minimal.ListRankedTargetsRequest), or inferred from the error responses if it's empty.
+// The returned set has every discovered package as a file named after FileName, along with the files they import so
+// that it is self-contained, dependencies first.
+func (p *Prober) Probe(ctx context.Context, url string, rootType string) (*descriptorpb.FileDescriptorSet, error) {
+	return p.newSession(ctx).run(url, rootType)
+}
+
+func (p *Prober) newSession(ctx context.Context) *session {
+	return &session{
+		Prober:               p,
+		ctx:                  ctx,
+		client:               newHTTPClient(p.transport, p.qps, p.maxInFlight, p.retries, time.Second, p.logger),
+		packageFDProtoMap:    make(map[string]*descriptorpb.FileDescriptorProto),
+		packageDependencyMap: make(map[string][]string),
+		fieldDiscoveryMap:    make(map[*descriptorpb.FieldDescriptorProto][]string),
+		fieldAnnotationMap:   make(map[*descriptorpb.FieldDescriptorProto]string),
+		knownTypes:           make(map[string]knownType),
+		importedFiles:        &protoregistry.Files{},
+		provisionalFields:    make(map[*descriptorpb.FieldDescriptorProto]bool),
+		serviceFDProtoMap:    make(map[string]*descriptorpb.FileDescriptorProto),
+	}
+}
+
+func (s *session) run(rawURL string, rootType string) (*descriptorpb.FileDescriptorSet, error) {
+	if s.concurrency < 1 {
+		return nil, fmt.Errorf("concurrency must be at least 1, got %d", s.concurrency)
+	}
+
+	for _, path := range s.importPaths {
+		if err := s.loadImportPath(path); err != nil {
+			return nil, fmt.Errorf("unable to load import path %s: %w", path, err)
+		}
+	}
+
+	for _, path := range s.knownPaths {
+		if err := s.loadKnownPath(path); err != nil {
+			return nil, fmt.Errorf("unable to load known definitions %s: %w", path, err)
+		}
+	}
+
+	var err error
+	s.url, err = setAltParameter(rawURL, s.alt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+
+	r1, err := s.testAPI(genPayload(nil, "str"))
+	if err != nil {
+		return nil, fmt.Errorf("error when testing api: %w", err)
+	}
+	r2, err := s.testAPI(genPayload(nil, "int"))
+	if err != nil {
+		return nil, fmt.Errorf("error when testing api: %w", err)
+	}
+	if r1.StatusCode != 400 && r2.StatusCode != 400 {
+		return nil, fmt.Errorf("unknown status code %d: %s", r2.StatusCode, r2.Body)
+	}
+
+	s.rpcMethod = s.errorMethod(r1, r2)
+
+	// a resumed run gets the request type from its checkpoint
+	if rootType == "" && s.resumeDir == "" {
+		rootType, err = s.inferRequestType()
+		if err != nil {
+			return nil, fmt.Errorf("unable to infer request type: %w", err)
+		}
+	}
+
+	q := newProbeQueue()
+	s.rootMessage = rootType
+
+	// a resumed run keeps saving checkpoints to the directory it was resumed from
+	checkpointDir := s.checkpointDir
+	if s.resumeDir != "" && checkpointDir == "" {
+		checkpointDir = s.resumeDir
+	}
+
+	if s.resumeDir != "" {
+		queue, root, err := s.loadCheckpoint(s.resumeDir)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load checkpoint %s: %w", s.resumeDir, err)
+		}
+		if root != "" {
+			s.rootMessage = root
+		}
+
+		s.logger.Info().Str("dir", s.resumeDir).Int("queued", len(queue)).Msg("resuming from checkpoint")
+		for _, data := range queue {
+			q.push(data)
+		}
+	} else {
+		x := messageRe.FindStringSubmatch(rootType)
+		if x == nil {
+			return nil, fmt.Errorf("invalid request type name %q", rootType)
+		}
+		packageName := x[1]
+		messageName := x[2]
+
+		var descProto *descriptorpb.DescriptorProto
+		if fdproto, ok := s.packageFDProtoMap[packageName]; ok {
+			// the package was seeded with WithKnown, so the newly discovered fields are merged into the known message
+			descProto, _, err = getOrCreateMessageDescriptor(fdproto, messageName)
+			if err != nil || descProto == nil {
+				return nil, fmt.Errorf("unable to create request message %s: %v", rootType, err)
+			}
+		} else {
+			s.packageFDProtoMap[packageName] = &descriptorpb.FileDescriptorProto{
+				Name:    proto.String(FileName(packageName)),
+				Syntax:  proto.String("proto3"),
+				Package: proto.String(packageName),
+				MessageType: []*descriptorpb.DescriptorProto{
+					{
+						Name:       proto.String(messageName),
+						Field:      []*descriptorpb.FieldDescriptorProto{},
+						NestedType: []*descriptorpb.DescriptorProto{},
+					},
+				},
+			}
+
+			fdproto := s.packageFDProtoMap[packageName]
+			descProto = fdproto.MessageType[0]
+		}
+
+		// ParentDescProto is nil for initial
+		q.push(MsgChData{Package: packageName, Message: messageName, Index: []int{}, DescProto: descProto})
+	}
+
+	if checkpointDir != "" {
+		s.checkpoint = &checkpointer{dir: checkpointDir, interval: s.checkpointInterval, last: time.Now()}
+	}
+
+	// the workers only return once the queue runs empty, which never happens if nothing was queued to begin with
+	if pending, _ := q.counts(); pending > 0 {
+		var wg sync.WaitGroup
+		for i := 0; i < s.concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.probeNestedMessageWorker(q)
+			}()
+		}
+		wg.Wait()
+	}
+
+	crawlErr := q.failure()
+	if crawlErr == nil && s.enumValues > 0 {
+		s.probeEnumValues(s.enumValues)
+	}
+
+	// save the finished state as well, so a later resume only regenerates the output. If the crawl failed, the
+	// messages that weren't applied are still in the queue, and will be probed again on resume.
+	if s.checkpoint != nil {
+		if err := s.saveCheckpoint(q); err != nil {
+			s.logger.Error().Err(err).Str("dir", s.checkpoint.dir).Msg("unable to save checkpoint")
+		}
+	}
+	if crawlErr != nil {
+		return nil, crawlErr
+	}
+
+	for p, fdproto := range s.packageFDProtoMap {
+		fdproto.Dependency = append(fdproto.Dependency, s.packageDependencyMap[p]...)
+	}
+
+	processFileDescriptors(s.packageFDProtoMap)
+	// the passes below find fields by name, so the names come first
+	if s.fieldNames {
+		s.recoverFieldNames()
+	}
+	s.detectMapEntries()
+	if s.oneofs {
+		s.detectOneofs()
+	}
+	if s.bytesMessages {
+		s.detectBytesMessages()
+	}
+	if s.typeReport != nil {
+		s.typeReport(s.refineFieldTypes())
+	} else if len(s.provisionalFields) > 0 {
+		s.logger.Warn().Int("fields", len(s.provisionalFields)).Msg("the type of some fields was guessed, enable type refinement to check them")
+	}
+	if s.responseBody != nil {
+		if err := s.inferResponse(); err != nil {
+			return nil, fmt.Errorf("unable to infer response: %w", err)
+		}
+	}
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// go through packages in a fixed order so the output doesn't depend on map iteration
+	files := s.packageFiles()
+	for _, fdproto := range files {
+		s.cleanupDuplicateFields(fdproto)
+		if s.verbose {
+			s.logger.Debug().Msg(prototext.Format(fdproto))
+		}
+		fdproto.SourceCodeInfo = s.buildSourceCodeInfo(fdproto, s.sourceInfo)
+	}
+
+	if s.services {
+		if err := s.addServiceMethod(); err != nil {
+			return nil, err
+		}
+		files = append(files, s.serviceFiles()...)
+	}
+
+	// dependencies have to come first, both in the descriptor set and for a registry to resolve them
+	return &descriptorpb.FileDescriptorSet{
+		File: sortFilesTopologically(append(s.externalDependencies(files), files...)),
+	}, nil
+}
@@ -1,4 +1,4 @@
-package main
+package probe
 
 import (
 	"fmt"
@@ -52,20 +52,25 @@ func collectMessageEnumTypes(msg *descriptorpb.DescriptorProto, parentPath strin
 
 func updateFieldTypes(fd *descriptorpb.FileDescriptorProto, enumMap map[string]bool) {
 	updateMessageFieldTypes(fd.MessageType, *fd.Package, enumMap)
+
+	updateExtensionTypes(fd.Extension, enumMap)
+}
+
+// updateExtensionTypes marks the extensions of an enum type, which are discovered as messages as well
+func updateExtensionTypes(extensions []*descriptorpb.FieldDescriptorProto, enumMap map[string]bool) {
+	for _, ext := range extensions {
+		if enumMap[strings.TrimPrefix(ext.GetTypeName(), ".")] {
+			ext.Type = descriptorpb.FieldDescriptorProto_TYPE_ENUM.Enum()
+		}
+	}
 }
 
 func updateMessageFieldTypes(messages []*descriptorpb.DescriptorProto, parentPath string, enumMap map[string]bool) {
 	for _, msg := range messages {
 		currentPath := fmt.Sprintf("%s.%s", parentPath, *msg.Name)
-		fieldNames := make(map[string]bool)
+		renameDuplicateFields(msg)
 
 		for _, field := range msg.Field {
-			// Check for duplicate field names
-			if fieldNames[*field.Name] {
-				newName := fmt.Sprintf("%s_%s", *field.Name, generateRandomString())
-				field.Name = proto.String(newName)
-			}
-			fieldNames[*field.Name] = true
 
 			if field.TypeName != nil {
 				fullTypeName := strings.TrimPrefix(*field.TypeName, ".")
@@ -76,7 +81,39 @@ func updateMessageFieldTypes(messages []*descriptorpb.DescriptorProto, parentPat
 			}
 		}
 
+		updateExtensionTypes(msg.Extension, enumMap)
+
 		// Recursively update nested messages
 		updateMessageFieldTypes(msg.NestedType, currentPath, enumMap)
 	}
 }
+
+// renameDuplicateFields gives the fields sharing a name the field number as suffix, so the output stays the same
+// between runs. The suffix is repeated as long as another field already has the name, ex. tag_2_2 when tag_2 exists.
+func renameDuplicateFields(msg *descriptorpb.DescriptorProto) {
+	taken := make(map[string]bool, len(msg.Field))
+	for _, field := range msg.Field {
+		taken[field.GetName()] = true
+	}
+
+	seen := make(map[string]bool, len(msg.Field))
+	for _, field := range msg.Field {
+		name := field.GetName()
+		if !seen[name] {
+			seen[name] = true
+			continue
+		}
+
+		newName := fmt.Sprintf("%s_%d", name, field.GetNumber())
+		for taken[newName] {
+			newName = fmt.Sprintf("%s_%d", newName, field.GetNumber())
+		}
+		taken[newName], seen[newName] = true, true
+
+		// the JSON name follows the new name, unless the server reported another one
+		if field.JsonName == nil || field.GetJsonName() == jsonName(name) {
+			field.JsonName = proto.String(jsonName(newName))
+		}
+		field.Name = proto.String(newName)
+	}
+}
@@ -0,0 +1,38 @@
+package probe
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestRenameDuplicateFields(t *testing.T) {
+	msg := &descriptorpb.DescriptorProto{Name: proto.String("Item")}
+	for _, field := range []struct {
+		name   string
+		number int32
+	}{{"tag", 1}, {"tag", 2}, {"tag_2", 3}, {"tag", 4}, {"name", 5}} {
+		msg.Field = append(msg.Field, &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(field.name),
+			Number:   proto.Int32(field.number),
+			JsonName: proto.String(jsonName(field.name)),
+		})
+	}
+
+	renameDuplicateFields(msg)
+
+	var names, jsonNames []string
+	for _, field := range msg.Field {
+		names = append(names, field.GetName())
+		jsonNames = append(jsonNames, field.GetJsonName())
+	}
+	// tag_2 is taken by field 3, so field 2 gets the suffix twice
+	if want := []string{"tag", "tag_2_2", "tag_2", "tag_4", "name"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names: want %v, got %v", want, names)
+	}
+	if want := []string{"tag", "tag22", "tag2", "tag4", "name"}; !reflect.DeepEqual(jsonNames, want) {
+		t.Errorf("json names: want %v, got %v", want, jsonNames)
+	}
+}
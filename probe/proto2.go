@@ -0,0 +1,255 @@
+package probe
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// extensions are reported by full name, like in the text format, ex. item.[google.example.priority]
+var extensionPathRe = regexp.MustCompile(`(?:^|\.)\[([A-Za-z_][A-Za-z0-9_.]*)\]$`)
+
+// addGroupField adds a group, whose type is a message nested in the parent named after the field, ex. group Result
+// for result. The group is probed next like a message, with the required fields reported for it.
+func (s *session) addGroupField(q *probeQueue, msgChData MsgChData, fieldName string, number int, label *descriptorpb.FieldDescriptorProto_Label, requiredFields []string, description string) {
+	// the field name of a group is the lowercase name of its type
+	groupName := strings.ToUpper(fieldName[:1]) + fieldName[1:]
+	nested := findOrCreateMessage(&msgChData.DescProto.NestedType, groupName)
+
+	field := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(fieldName),
+		Number:   proto.Int32(int32(number)),
+		Label:    label,
+		Type:     descriptorpb.FieldDescriptorProto_TYPE_GROUP.Enum(),
+		TypeName: proto.String("." + msgChData.Package + "." + msgChData.Message + "." + groupName),
+		JsonName: proto.String(jsonName(fieldName)),
+	}
+	msgChData.DescProto.Field = append(msgChData.DescProto.Field, field)
+	s.noteFieldDiscovery(field, "discovered as a group at index %v: %s", msgChData.Index, description)
+	s.packageFDProtoMap[msgChData.Package].Syntax = proto.String("proto2")
+
+	if s.maxDepth < 0 || !(len(msgChData.Index) == s.maxDepth) {
+		newIndex := append(msgChData.Index, number)
+		repeated := *label == descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+		if repeated {
+			newIndex = append(newIndex, 1)
+		}
+		q.push(MsgChData{Package: msgChData.Package, Message: msgChData.Message + "." + groupName, DescProto: nested, ParentDescProto: msgChData.DescProto, Index: newIndex, Element: repeated, RequiredFieldsToLabel: requiredFields})
+	}
+}
+
+// addExtension adds an extension of the message, declared in the message or the package its full name is scoped to.
+// Extensions of a message type are probed next like a field.
+func (s *session) addExtension(q *probeQueue, msgChData MsgChData, fullName string, description string) {
+	matches := fieldDescRe.FindStringSubmatch(description)
+	if len(matches) < 4 {
+		s.logger.Error().Str("description", description).Str("message", msgChData.Message).Msg("unable to parse extension violation")
+		return
+	}
+	number, err := strconv.Atoi(matches[3])
+	if err != nil {
+		return
+	}
+
+	packageName, scope, name, ok := s.extensionScope(fullName)
+	if !ok {
+		return
+	}
+	fdproto := s.packageFDProtoMap[packageName]
+	extensions := &fdproto.Extension
+	if scope != nil {
+		extensions = &scope.Extension
+	}
+
+	extendee := "." + msgChData.Package + "." + msgChData.Message
+	for _, ext := range *extensions {
+		if ext.GetExtendee() == extendee && int(ext.GetNumber()) == number {
+			return
+		}
+	}
+
+	field := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		Number:   proto.Int32(int32(number)),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Extendee: proto.String(extendee),
+		JsonName: proto.String(jsonName(name)),
+	}
+
+	switch {
+	case typeMap[matches[2]] != nil:
+		field.Type = typeMap[matches[2]]
+	case strings.HasPrefix(matches[2], "type.googleapis.com/"):
+		typeName := strings.TrimPrefix(matches[2], "type.googleapis.com/")
+		field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+		field.TypeName = proto.String("." + typeName)
+
+		if desc, ok := s.resolveExternalType(typeName); ok {
+			s.addPackageDependency(packageName, desc.ParentFile().Path())
+			if _, isEnum := desc.(protoreflect.EnumDescriptor); isEnum {
+				field.Type = descriptorpb.FieldDescriptorProto_TYPE_ENUM.Enum()
+			}
+			break
+		}
+
+		x := messageRe.FindStringSubmatch(typeName)
+		if x == nil {
+			return
+		}
+		typeFDProto, ok := s.packageFDProtoMap[x[1]]
+		if !ok {
+			typeFDProto = &descriptorpb.FileDescriptorProto{
+				Name:    proto.String(FileName(x[1])),
+				Syntax:  proto.String("proto3"),
+				Package: proto.String(x[1]),
+			}
+			s.packageFDProtoMap[x[1]] = typeFDProto
+		}
+		descProto, enum, err := getOrCreateMessageDescriptor(typeFDProto, x[2])
+		if err != nil {
+			return
+		}
+		if enum != nil {
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_ENUM.Enum()
+		} else if s.maxDepth < 0 || !(len(msgChData.Index) == s.maxDepth) {
+			q.push(MsgChData{Package: x[1], Message: x[2], DescProto: descProto, ParentDescProto: msgChData.DescProto, Index: append(msgChData.Index, number)})
+		}
+		if x[1] != packageName {
+			s.addPackageDependency(packageName, FileName(x[1]))
+		}
+	default:
+		s.logger.Error().Str("description", description).Str("message", msgChData.Message).Msg("unable to parse extension violation")
+		return
+	}
+
+	// only proto2 messages have extensions, and only proto2 files can declare them for other messages than options
+	*extensions = append(*extensions, field)
+	if len(msgChData.DescProto.ExtensionRange) == 0 {
+		s.logger.Warn().Str("message", msgChData.Message).Msg("the extension ranges of the message aren't detected, only the numbers of the discovered extensions are declared")
+	}
+	coverExtensionNumber(msgChData.DescProto, int32(number))
+	fdproto.Syntax = proto.String("proto2")
+	s.packageFDProtoMap[msgChData.Package].Syntax = proto.String("proto2")
+	if packageName != msgChData.Package {
+		s.addPackageDependency(packageName, FileName(msgChData.Package))
+	}
+
+	if s.verbose {
+		s.logger.Debug().Str("extension", fullName).Str("extendee", extendee).Int("number", number).Msg("discovered extension")
+	}
+}
+
+// extensionScope resolves where an extension is declared from its full name. The scope is a message when it's one of
+// the known messages, ex. pkg.Outer for pkg.Outer.ext, or when it ends in a message name like the type names do,
+// otherwise it's the package, whose file is created if needed.
+func (s *session) extensionScope(fullName string) (string, *descriptorpb.DescriptorProto, string, bool) {
+	i := strings.LastIndex(fullName, ".")
+	if i < 0 {
+		return "", nil, "", false
+	}
+	scopeName, name := fullName[:i], fullName[i+1:]
+	if _, ok := s.packageFDProtoMap[scopeName]; ok {
+		return scopeName, nil, name, true
+	}
+
+	// the longest package with a message of that name, as packages can be nested
+	var packageName string
+	var scope *descriptorpb.DescriptorProto
+	for pkg, fdproto := range s.packageFDProtoMap {
+		if len(pkg) <= len(packageName) || !strings.HasPrefix(scopeName, pkg+".") {
+			continue
+		}
+		if msg := findMessage(fdproto.MessageType, scopeName[len(pkg)+1:]); msg != nil {
+			packageName, scope = pkg, msg
+		}
+	}
+	if scope != nil {
+		return packageName, scope, name, true
+	}
+
+	if x := messageRe.FindStringSubmatch(scopeName); x != nil {
+		fdproto, ok := s.packageFDProtoMap[x[1]]
+		if !ok {
+			fdproto = &descriptorpb.FileDescriptorProto{
+				Name:    proto.String(FileName(x[1])),
+				Syntax:  proto.String("proto2"),
+				Package: proto.String(x[1]),
+			}
+			s.packageFDProtoMap[x[1]] = fdproto
+		}
+		msg, enum, err := getOrCreateMessageDescriptor(fdproto, x[2])
+		if err != nil || enum != nil {
+			return "", nil, "", false
+		}
+		return x[1], msg, name, true
+	}
+
+	s.packageFDProtoMap[scopeName] = &descriptorpb.FileDescriptorProto{
+		Name:    proto.String(FileName(scopeName)),
+		Syntax:  proto.String("proto2"),
+		Package: proto.String(scopeName),
+	}
+	return scopeName, nil, name, true
+}
+
+// findMessage looks up a message by its dotted path in the package, without creating it
+func findMessage(messages []*descriptorpb.DescriptorProto, path string) *descriptorpb.DescriptorProto {
+	var found *descriptorpb.DescriptorProto
+	for _, part := range strings.Split(path, ".") {
+		found = nil
+		for _, msg := range messages {
+			if msg.GetName() == part {
+				found = msg
+				break
+			}
+		}
+		if found == nil {
+			return nil
+		}
+		messages = found.NestedType
+	}
+	return found
+}
+
+// coverExtensionNumber adds the number of an extension to the extension ranges of the message, as protodesc requires.
+// These are placeholders, merged when adjacent, and not the declared ranges: detecting those from the error text is
+// still to be done, as it needs the wording of a real server for a number without an extension.
+func coverExtensionNumber(msg *descriptorpb.DescriptorProto, number int32) {
+	var grown *descriptorpb.DescriptorProto_ExtensionRange
+	for _, r := range msg.ExtensionRange {
+		switch {
+		case number >= r.GetStart() && number < r.GetEnd():
+			return
+		case grown == nil && r.GetEnd() == number:
+			r.End = proto.Int32(number + 1)
+			grown = r
+		case grown == nil && r.GetStart() == number+1:
+			r.Start = proto.Int32(number)
+			grown = r
+		}
+	}
+	if grown == nil {
+		msg.ExtensionRange = append(msg.ExtensionRange, &descriptorpb.DescriptorProto_ExtensionRange{
+			Start: proto.Int32(number),
+			End:   proto.Int32(number + 1),
+		})
+		return
+	}
+
+	// the range may now touch the next one
+	for i, r := range msg.ExtensionRange {
+		if r == grown {
+			continue
+		}
+		if r.GetStart() == grown.GetEnd() || r.GetEnd() == grown.GetStart() {
+			grown.Start = proto.Int32(min(grown.GetStart(), r.GetStart()))
+			grown.End = proto.Int32(max(grown.GetEnd(), r.GetEnd()))
+			msg.ExtensionRange = append(msg.ExtensionRange[:i], msg.ExtensionRange[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package probe
+
+import (
+	"context"
+	"reflect"
+	"req2proto/gapitest"
+	"req2proto/parser"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func TestProbeProto2(t *testing.T) {
+	const requestType = "google.internal.test.legacy.SearchRequest"
+	server, err := gapitest.NewServer(loadTestSchema(t), requestType)
+	if err != nil {
+		t.Fatalf("unable to create fake server: %v", err)
+	}
+
+	p := New(WithTransport(NewHandlerTransport(server)), WithRetries(0))
+	set, err := p.Probe(context.Background(), testURL, requestType)
+	if err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		t.Fatalf("output doesn't resolve: %v", err)
+	}
+	fd, err := files.FindFileByPath(FileName("google.internal.test.legacy"))
+	if err != nil {
+		t.Fatalf("no legacy file: %v", err)
+	}
+	if fd.Syntax() != protoreflect.Proto2 {
+		t.Errorf("want proto2, got %v", fd.Syntax())
+	}
+
+	request := fd.Messages().ByName("SearchRequest")
+	if request == nil {
+		t.Fatalf("no request message")
+	}
+
+	result := request.Fields().ByName("result")
+	if result == nil || result.Kind() != protoreflect.GroupKind || result.Message().Name() != "Result" {
+		t.Fatalf("result: want group Result, got %v", result)
+	}
+	if url := result.Message().Fields().ByName("url"); url == nil || url.Cardinality() != protoreflect.Required {
+		t.Errorf("url: want required field of the group, got %v", url)
+	}
+	if rank := result.Message().Fields().ByName("rank"); rank == nil || rank.Kind() != protoreflect.Int32Kind {
+		t.Errorf("rank: want int32 field of the group, got %v", rank)
+	}
+
+	// the declared ranges aren't reported, only the numbers of the discovered extensions are covered
+	if ranges := request.ExtensionRanges(); ranges.Len() != 1 || ranges.Get(0) != [2]protoreflect.FieldNumber{100, 103} {
+		t.Errorf("want extensions 100 to 102, got %v", ranges)
+	}
+	if ranges := fd.Messages().ByName("Options").ExtensionRanges(); ranges.Len() != 0 {
+		t.Errorf("Options: want no extension ranges, got %v", ranges)
+	}
+
+	tracking := fd.Messages().ByName("Tracking")
+	if tracking == nil {
+		t.Fatalf("no message for the scope of the campaign extension")
+	}
+	for _, want := range []struct {
+		scope  protoreflect.ExtensionDescriptors
+		name   protoreflect.Name
+		number protoreflect.FieldNumber
+		kind   protoreflect.Kind
+	}{
+		{fd.Extensions(), "priority", 100, protoreflect.Int32Kind},
+		{fd.Extensions(), "fallback", 101, protoreflect.MessageKind},
+		{tracking.Extensions(), "campaign", 102, protoreflect.StringKind},
+	} {
+		ext := want.scope.ByName(want.name)
+		if ext == nil {
+			t.Errorf("extension %s is missing", want.name)
+			continue
+		}
+		if ext.Number() != want.number || ext.Kind() != want.kind || ext.ContainingMessage().FullName() != requestType {
+			t.Errorf("extension %s: want %v = %d of %s, got %v = %d of %s", want.name, want.kind, want.number, requestType, ext.Kind(), ext.Number(), ext.ContainingMessage().FullName())
+		}
+	}
+
+	generated := parser.GenerateProtoFile(fd)
+	for _, want := range []string{
+		"optional group Result = 2 {\n    required string url = 1;",
+		"extensions 100 to 102;",
+		"message Tracking {\n\n  extend SearchRequest {\n    optional string campaign = 102;\n  }\n}",
+		"extend SearchRequest {\n  optional int32 priority = 100;\n  optional Options fallback = 101;\n}",
+	} {
+		if !strings.Contains(generated, want) {
+			t.Errorf("generated proto is missing %q:\n%s", want, generated)
+		}
+	}
+}
+
+func TestCoverExtensionNumber(t *testing.T) {
+	for _, tc := range []struct {
+		numbers []int32
+		want    [][2]int32
+	}{
+		{[]int32{100, 101, 102}, [][2]int32{{100, 103}}},
+		{[]int32{101, 100}, [][2]int32{{100, 102}}},
+		{[]int32{100, 102, 101}, [][2]int32{{100, 103}}},
+		{[]int32{100, 200, 100}, [][2]int32{{100, 101}, {200, 201}}},
+	} {
+		msg := &descriptorpb.DescriptorProto{}
+		for _, number := range tc.numbers {
+			coverExtensionNumber(msg, number)
+		}
+		var got [][2]int32
+		for _, r := range msg.ExtensionRange {
+			got = append(got, [2]int32{r.GetStart(), r.GetEnd()})
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%v: want ranges %v, got %v", tc.numbers, tc.want, got)
+		}
+	}
+}
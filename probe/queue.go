@@ -0,0 +1,144 @@
+package probe
+
+import (
+	"sort"
+	"sync"
+)
+
+// probeQueue hands out messages to the probe workers. Requests are sent concurrently,
+// but the results are applied one at a time in the order the messages were queued,
+// so the output is the same no matter how many workers are running.
+//
+// The queue is unbounded, as the worker holding the turn pushes the children of its
+// message while every other worker may be waiting for the turn, and blocking there
+// would never let the turn go.
+//
+// The queue also keeps count of the messages waiting to be probed (pending) and the
+// ones currently being probed (inFlight). Once both reach zero nothing can queue new
+// messages anymore, so the queue is closed and the workers return. The workers also
+// return once the queue is aborted, see abort.
+type probeQueue struct {
+	err error
+
+	mu        sync.Mutex
+	turn      *sync.Cond
+	available *sync.Cond
+	items     []MsgChData
+	closed    bool
+	nextSeq   int
+	applySeq  int
+	pending   int
+	inFlight  int
+
+	// every message that hasn't been applied yet, by seq, for checkpoints
+	unapplied map[int]MsgChData
+}
+
+func newProbeQueue() *probeQueue {
+	q := &probeQueue{unapplied: make(map[int]MsgChData)}
+	q.turn = sync.NewCond(&q.mu)
+	q.available = sync.NewCond(&q.mu)
+	return q
+}
+
+// push queues a message to be probed. It must only be called before the workers are
+// started, or by the worker currently holding the turn. It never blocks.
+func (q *probeQueue) push(data MsgChData) {
+	// copy the index, as siblings are created with append() on the same parent index
+	data.Index = append([]int(nil), data.Index...)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	data.seq = q.nextSeq
+	q.nextSeq++
+	q.pending++
+	q.unapplied[data.seq] = data
+	q.items = append(q.items, data)
+	q.available.Signal()
+}
+
+// pop returns the next message to probe, or false once all work is done or the queue was aborted
+func (q *probeQueue) pop() (MsgChData, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.items) == 0 && !q.closed && q.err == nil {
+		q.available.Wait()
+	}
+	if q.err != nil || len(q.items) == 0 {
+		return MsgChData{}, false
+	}
+
+	data := q.items[0]
+	q.items[0] = MsgChData{}
+	q.items = q.items[1:]
+	q.pending--
+	q.inFlight++
+	return data, true
+}
+
+// waitTurn blocks until every message queued before seq has been applied. It returns false if the queue was
+// aborted in the meantime, in which case the message must not be applied.
+func (q *probeQueue) waitTurn(seq int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.applySeq != seq && q.err == nil {
+		q.turn.Wait()
+	}
+	return q.err == nil
+}
+
+// abort stops the workers, the messages that weren't applied yet stay in outstanding. Only the first error is kept.
+func (q *probeQueue) abort(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err != nil {
+		return
+	}
+	q.err = err
+	q.turn.Broadcast()
+	q.available.Broadcast()
+}
+
+// failure returns the error the queue was aborted with, if any
+func (q *probeQueue) failure() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.err
+}
+
+// finishTurn marks the current message as done and lets the next queued message be applied.
+// Any children must have been pushed before this is called.
+func (q *probeQueue) finishTurn() {
+	q.mu.Lock()
+	delete(q.unapplied, q.applySeq)
+	q.applySeq++
+	q.inFlight--
+	if q.pending == 0 && q.inFlight == 0 {
+		q.closed = true
+		q.available.Broadcast()
+	}
+	q.turn.Broadcast()
+	q.mu.Unlock()
+}
+
+// counts returns the number of pending and in-flight messages
+func (q *probeQueue) counts() (pending int, inFlight int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.pending, q.inFlight
+}
+
+// outstanding returns every message that hasn't been applied yet, in the order they were queued
+func (q *probeQueue) outstanding() []MsgChData {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	result := make([]MsgChData, 0, len(q.unapplied))
+	for _, data := range q.unapplied {
+		result = append(result, data)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].seq < result[j].seq
+	})
+	return result
+}
@@ -0,0 +1,78 @@
+package probe
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProbeQueueWide(t *testing.T) {
+	// more children than the workers could ever hold, queued by the worker holding the turn
+	const children = 2500
+	q := newProbeQueue()
+	q.push(MsgChData{Message: "Root", Index: []int{}})
+
+	var mu sync.Mutex
+	var applied []string
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				data, ok := q.pop()
+				if !ok {
+					return
+				}
+				if !q.waitTurn(data.seq) {
+					return
+				}
+				mu.Lock()
+				applied = append(applied, data.Message)
+				mu.Unlock()
+				if data.Message == "Root" {
+					for n := 1; n <= children; n++ {
+						q.push(MsgChData{Message: "Child", Index: []int{n}})
+					}
+				}
+				q.finishTurn()
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("the workers are stuck")
+	}
+
+	if len(applied) != children+1 {
+		t.Errorf("want %d messages applied, got %d", children+1, len(applied))
+	}
+	if pending, inFlight := q.counts(); pending != 0 || inFlight != 0 {
+		t.Errorf("want an empty queue, got %d pending and %d in flight", pending, inFlight)
+	}
+}
+
+func TestProbeQueueAbort(t *testing.T) {
+	q := newProbeQueue()
+	for n := 0; n < 1500; n++ {
+		q.push(MsgChData{Message: "Item", Index: []int{n}})
+	}
+	if _, ok := q.pop(); !ok {
+		t.Fatalf("want a message")
+	}
+	q.abort(errors.New("stopped"))
+	if _, ok := q.pop(); ok {
+		t.Errorf("want no message after abort")
+	}
+	if outstanding := q.outstanding(); len(outstanding) != 1500 {
+		t.Errorf("want every unapplied message to stay outstanding, got %d", len(outstanding))
+	}
+}
@@ -0,0 +1,150 @@
+package probe
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"unicode/utf8"
+)
+
+// ErrNotRecorded is returned by a replay Transport for a request that wasn't recorded
+var ErrNotRecorded = errors.New("request wasn't recorded")
+
+// Exchange is a request and the response to it, one per line of a recording. Request headers are left out, as they
+// usually hold credentials.
+type Exchange struct {
+	Method      string `json:"method"`
+	URL         string `json:"url"`
+	RequestBody string `json:"request_body"`
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type,omitempty"`
+	// base64 if the response isn't valid UTF-8, ex. a binary status with alt=proto
+	ResponseBody     string `json:"response_body"`
+	ResponseEncoding string `json:"response_encoding,omitempty"`
+}
+
+// recordingTransport writes every exchange to w as a line of JSON
+type recordingTransport struct {
+	transport Transport
+
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewRecordingTransport returns a Transport sending requests with transport, or NewFastHTTPTransport(nil) if it's
+// nil, that writes every exchange to w. Requests that fail without a response aren't recorded.
+func NewRecordingTransport(transport Transport, w io.Writer) Transport {
+	if transport == nil {
+		transport = NewFastHTTPTransport(nil)
+	}
+	return &recordingTransport{transport: transport, encoder: json.NewEncoder(w)}
+}
+
+func (t *recordingTransport) Do(ctx context.Context, req *Request) (*Response, error) {
+	resp, err := t.transport.Do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	exchange := Exchange{
+		Method:       req.Method,
+		URL:          req.URL,
+		RequestBody:  string(req.Body),
+		Status:       resp.StatusCode,
+		ContentType:  resp.Header.Get("Content-Type"),
+		ResponseBody: string(resp.Body),
+	}
+	if !utf8.Valid(resp.Body) {
+		exchange.ResponseBody = base64.StdEncoding.EncodeToString(resp.Body)
+		exchange.ResponseEncoding = "base64"
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.encoder.Encode(exchange); err != nil {
+		return nil, fmt.Errorf("unable to record exchange: %w", err)
+	}
+	return resp, nil
+}
+
+// LoadExchanges reads a recording written by NewRecordingTransport
+func LoadExchanges(r io.Reader) ([]Exchange, error) {
+	var exchanges []Exchange
+
+	scanner := bufio.NewScanner(r)
+	// responses can be larger than the default limit of a line
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var exchange Exchange
+		if err := json.Unmarshal(scanner.Bytes(), &exchange); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		exchanges = append(exchanges, exchange)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return exchanges, nil
+}
+
+// replayTransport answers requests with the recorded responses to the same method, URL and body
+type replayTransport struct {
+	mu        sync.Mutex
+	responses map[string][]Exchange
+}
+
+// NewReplayTransport returns a Transport that answers requests from a recording, without going through the network.
+// A request recorded several times gets the recorded responses in order (ex. a 503 and the response to the retry),
+// and the last one after that. Other requests fail with ErrNotRecorded.
+func NewReplayTransport(exchanges []Exchange) Transport {
+	t := &replayTransport{responses: make(map[string][]Exchange)}
+	for _, exchange := range exchanges {
+		key := replayKey(exchange.Method, exchange.URL, exchange.RequestBody)
+		t.responses[key] = append(t.responses[key], exchange)
+	}
+	return t
+}
+
+func replayKey(method string, url string, body string) string {
+	return method + " " + url + "\n" + body
+}
+
+func (t *replayTransport) Do(ctx context.Context, req *Request) (*Response, error) {
+	t.mu.Lock()
+	key := replayKey(req.Method, req.URL, string(req.Body))
+	recorded := t.responses[key]
+	if len(recorded) == 0 {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s %s %s", ErrNotRecorded, req.Method, req.URL, req.Body)
+	}
+	exchange := recorded[0]
+	if len(recorded) > 1 {
+		t.responses[key] = recorded[1:]
+	}
+	t.mu.Unlock()
+
+	body := []byte(exchange.ResponseBody)
+	if exchange.ResponseEncoding == "base64" {
+		var err error
+		body, err = base64.StdEncoding.DecodeString(exchange.ResponseBody)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recorded response: %w", err)
+		}
+	}
+
+	header := make(http.Header)
+	if exchange.ContentType != "" {
+		header.Set("Content-Type", exchange.ContentType)
+	}
+	return &Response{StatusCode: exchange.Status, Header: header, Body: body}, nil
+}
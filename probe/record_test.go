@@ -0,0 +1,52 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"req2proto/gapitest"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func TestReplayReproducesProbe(t *testing.T) {
+	for _, alt := range []string{"json", "proto"} {
+		t.Run(alt, func(t *testing.T) {
+			server, err := gapitest.NewServer(loadTestSchema(t), testRequest)
+			if err != nil {
+				t.Fatalf("unable to create fake server: %v", err)
+			}
+
+			var recording bytes.Buffer
+			recorder := NewRecordingTransport(NewHandlerTransport(server), &recording)
+			want, err := New(WithTransport(recorder), WithRetries(0), WithResponseEncoding(alt)).Probe(context.Background(), testURL, testRequest)
+			if err != nil {
+				t.Fatalf("probe failed: %v", err)
+			}
+
+			exchanges, err := LoadExchanges(&recording)
+			if err != nil {
+				t.Fatalf("unable to load recording: %v", err)
+			}
+			if len(exchanges) == 0 {
+				t.Fatalf("nothing was recorded")
+			}
+
+			replay := NewReplayTransport(exchanges)
+			got, err := New(WithTransport(replay), WithRetries(0), WithResponseEncoding(alt)).Probe(context.Background(), testURL, testRequest)
+			if err != nil {
+				t.Fatalf("replay failed: %v", err)
+			}
+			if !proto.Equal(want, got) {
+				t.Errorf("replayed output differs from the recorded probe")
+			}
+
+			// a request that wasn't recorded fails right away instead of being retried
+			_, err = New(WithTransport(replay), WithResponseEncoding(alt)).Probe(context.Background(), "https://test-pa.googleapis.com/v1/items:delete", testRequest)
+			if !errors.Is(err, ErrNotRecorded) {
+				t.Errorf("want ErrNotRecorded for a request that wasn't recorded, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,323 @@
+package probe
+
+import (
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// provisionalType returns the type to assume for a field whose violation doesn't name the type. A field rejecting a
+// string is numeric, so it gets the widest numeric type until it's refined, otherwise it's most likely a string.
+func provisionalType(rejectedString bool) string {
+	if rejectedString {
+		return "TYPE_DOUBLE"
+	}
+	return "TYPE_STRING"
+}
+
+// boundaryValues are sent to every numeric field, the ones accepted tell apart the range of the type
+var boundaryValues = []struct {
+	Name  string
+	Value interface{}
+}{
+	{"2^31", 2147483648},
+	{"2^32", 4294967296},
+	{"-1", -1},
+	{"1.5", 1.5},
+	{"1e39", 1e39},
+	{"NaN", "NaN"},
+	{"2^63", uint64(1) << 63},
+}
+
+// numericClass is a set of types with the same range, which can't be told apart through JSPB as they only differ
+// in their wire encoding. Accepts lists whether each of the boundaryValues is in range.
+type numericClass struct {
+	Name    string
+	Type    descriptorpb.FieldDescriptorProto_Type
+	Members []descriptorpb.FieldDescriptorProto_Type
+	Accepts []bool
+}
+
+// numericClasses goes from the narrowest to the widest
+var numericClasses = []numericClass{
+	{
+		Name:    "int32",
+		Type:    descriptorpb.FieldDescriptorProto_TYPE_INT32,
+		Members: []descriptorpb.FieldDescriptorProto_Type{descriptorpb.FieldDescriptorProto_TYPE_INT32, descriptorpb.FieldDescriptorProto_TYPE_SINT32, descriptorpb.FieldDescriptorProto_TYPE_SFIXED32},
+		Accepts: []bool{false, false, true, false, false, false, false},
+	},
+	{
+		Name:    "uint32",
+		Type:    descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+		Members: []descriptorpb.FieldDescriptorProto_Type{descriptorpb.FieldDescriptorProto_TYPE_UINT32, descriptorpb.FieldDescriptorProto_TYPE_FIXED32},
+		Accepts: []bool{true, false, false, false, false, false, false},
+	},
+	{
+		Name:    "int64",
+		Type:    descriptorpb.FieldDescriptorProto_TYPE_INT64,
+		Members: []descriptorpb.FieldDescriptorProto_Type{descriptorpb.FieldDescriptorProto_TYPE_INT64, descriptorpb.FieldDescriptorProto_TYPE_SINT64, descriptorpb.FieldDescriptorProto_TYPE_SFIXED64},
+		Accepts: []bool{true, true, true, false, false, false, false},
+	},
+	{
+		Name:    "uint64",
+		Type:    descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+		Members: []descriptorpb.FieldDescriptorProto_Type{descriptorpb.FieldDescriptorProto_TYPE_UINT64, descriptorpb.FieldDescriptorProto_TYPE_FIXED64},
+		Accepts: []bool{true, true, false, false, false, false, true},
+	},
+	{
+		Name:    "float",
+		Type:    descriptorpb.FieldDescriptorProto_TYPE_FLOAT,
+		Members: []descriptorpb.FieldDescriptorProto_Type{descriptorpb.FieldDescriptorProto_TYPE_FLOAT},
+		Accepts: []bool{true, true, true, true, false, true, true},
+	},
+	{
+		Name:    "double",
+		Type:    descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+		Members: []descriptorpb.FieldDescriptorProto_Type{descriptorpb.FieldDescriptorProto_TYPE_DOUBLE},
+		Accepts: []bool{true, true, true, true, true, true, true},
+	},
+}
+
+// TypeRefinement is an entry of the type report, for every field whose type was changed or is still uncertain
+type TypeRefinement struct {
+	Field      string          `json:"field"`
+	Reported   string          `json:"reported"`
+	Refined    string          `json:"refined"`
+	Consistent []string        `json:"consistent"`
+	Accepted   map[string]bool `json:"accepted"`
+	Ambiguous  bool            `json:"ambiguous"`
+}
+
+func findNumericClass(fieldType descriptorpb.FieldDescriptorProto_Type) *numericClass {
+	for i := range numericClasses {
+		for _, member := range numericClasses[i].Members {
+			if member == fieldType {
+				return &numericClasses[i]
+			}
+		}
+	}
+	return nil
+}
+
+// refineFieldTypes sends the boundaryValues to every numeric field, and changes the type of the fields whose type is
+// inconsistent with what the server accepts to the narrowest consistent one. The fields of a message are probed
+// together, one request per value, except for fields of the same oneof. When fewer fields than sent are rejected,
+// the others are confirmed one at a time, as the server may only report the first violation.
+func (s *session) refineFieldTypes() []TypeRefinement {
+	messages, _ := collectSchema(s.packageFiles())
+	indices := messageIndices(s.rootMessage, messages)
+
+	messageNames := make([]string, 0, len(indices))
+	for name := range indices {
+		messageNames = append(messageNames, name)
+	}
+	sort.Strings(messageNames)
+
+	var report []TypeRefinement
+	refined := make(map[*descriptorpb.FieldDescriptorProto]bool)
+
+	for _, name := range messageNames {
+		msg, ok := messages[name]
+		if !ok {
+			continue
+		}
+
+		// a batch can't have two fields of the same oneof, as that would be rejected regardless of the values
+		var batches [][]*descriptorpb.FieldDescriptorProto
+		oneofCounts := make(map[int32]int)
+		for _, field := range msg.Field {
+			if field.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED || findNumericClass(field.GetType()) == nil {
+				continue
+			}
+
+			batch := 0
+			if field.OneofIndex != nil {
+				batch = oneofCounts[field.GetOneofIndex()]
+				oneofCounts[field.GetOneofIndex()]++
+			}
+			for len(batches) <= batch {
+				batches = append(batches, nil)
+			}
+			batches[batch] = append(batches[batch], field)
+		}
+
+		for _, batch := range batches {
+			// nil means the request failed, so the value is left out when comparing
+			accepted := make(map[*descriptorpb.FieldDescriptorProto][]*bool, len(batch))
+
+			for _, boundary := range boundaryValues {
+				rejected, err := s.rejectedFields(indices[name], batch, boundary.Value)
+				if err == nil && len(rejected) < len(batch) && len(batch) > 1 {
+					// some frontends stop at the first violation, so the fields without one are sent on their own
+					for _, field := range batch {
+						if rejected[field] {
+							continue
+						}
+						var alone map[*descriptorpb.FieldDescriptorProto]bool
+						if alone, err = s.rejectedFields(indices[name], []*descriptorpb.FieldDescriptorProto{field}, boundary.Value); err != nil {
+							break
+						}
+						rejected[field] = alone[field]
+					}
+				}
+				if err != nil {
+					s.logger.Error().Err(err).Str("message", name).Str("value", boundary.Name).Msg("error when refining types")
+					for _, field := range batch {
+						accepted[field] = append(accepted[field], nil)
+					}
+					continue
+				}
+
+				for _, field := range batch {
+					ok := !rejected[field]
+					accepted[field] = append(accepted[field], &ok)
+				}
+			}
+
+			for _, field := range batch {
+				refined[field] = true
+				if entry, ok := s.applyRefinement(name, field, accepted[field]); ok {
+					report = append(report, entry)
+
+					if s.verbose {
+						s.logger.Debug().Str("field", entry.Field).Str("reported", entry.Reported).Str("refined", entry.Refined).Bool("ambiguous", entry.Ambiguous).Msg("refined field type")
+					}
+				}
+			}
+		}
+	}
+
+	// fields that were never reached (ex. repeated ones) are still reported if their type was only guessed
+	for name, msg := range messages {
+		for _, field := range msg.Field {
+			if s.provisionalFields[field] && !refined[field] {
+				report = append(report, TypeRefinement{
+					Field:     name + "." + field.GetName(),
+					Reported:  "unknown",
+					Refined:   typeName(field.GetType()),
+					Ambiguous: true,
+				})
+			}
+		}
+	}
+
+	sort.Slice(report, func(i, j int) bool {
+		return report[i].Field < report[j].Field
+	})
+	return report
+}
+
+// rejectedFields sends value at every field of the message at index, and returns the fields with a violation
+func (s *session) rejectedFields(index []int, fields []*descriptorpb.FieldDescriptorProto, value interface{}) (map[*descriptorpb.FieldDescriptorProto]bool, error) {
+	maxNumber := int32(0)
+	for _, field := range fields {
+		maxNumber = max(maxNumber, field.GetNumber())
+	}
+	values := make([]interface{}, maxNumber)
+	for _, field := range fields {
+		values[field.GetNumber()-1] = value
+	}
+
+	violations, err := s.probeAPI(genValuePayload(index, values))
+	if err != nil {
+		return nil, err
+	}
+
+	rejected := make(map[*descriptorpb.FieldDescriptorProto]bool)
+	for _, violation := range violations {
+		for _, field := range fields {
+			if isViolationOf(violation.Field, field) {
+				rejected[field] = true
+			}
+		}
+	}
+	return rejected, nil
+}
+
+// applyRefinement changes the type of the field if needed, and returns a report entry if the type changed or is ambiguous
+func (s *session) applyRefinement(messageName string, field *descriptorpb.FieldDescriptorProto, accepted []*bool) (TypeRefinement, bool) {
+	entry := TypeRefinement{
+		Field:    messageName + "." + field.GetName(),
+		Reported: typeName(field.GetType()),
+		Accepted: make(map[string]bool),
+	}
+	if s.provisionalFields[field] {
+		entry.Reported = "unknown"
+	}
+
+	var consistent []*numericClass
+	for i := range numericClasses {
+		class := &numericClasses[i]
+		matches := true
+		for j, ok := range accepted {
+			if ok != nil && *ok != class.Accepts[j] {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			consistent = append(consistent, class)
+			entry.Consistent = append(entry.Consistent, class.Name)
+		}
+	}
+	for j, ok := range accepted {
+		if ok != nil {
+			entry.Accepted[boundaryValues[j].Name] = *ok
+		}
+	}
+
+	// a server that doesn't check the range at all can't tell anything
+	rangeChecked := false
+	for _, ok := range accepted {
+		if ok != nil && !*ok {
+			rangeChecked = true
+		}
+	}
+	if !rangeChecked {
+		entry.Refined = typeName(field.GetType())
+		entry.Ambiguous = true
+		return entry, true
+	}
+
+	reported := findNumericClass(field.GetType())
+	for _, class := range consistent {
+		// the reported type is more precise than the class, as it also tells the wire encoding
+		if class == reported && !s.provisionalFields[field] {
+			entry.Refined = entry.Reported
+			entry.Ambiguous = len(consistent) > 1
+			return entry, entry.Ambiguous
+		}
+	}
+
+	if len(consistent) == 0 {
+		entry.Refined = typeName(field.GetType())
+		entry.Ambiguous = true
+		s.noteFieldDiscovery(field, "type is uncertain, no type accepts the same values as the server did")
+		return entry, true
+	}
+
+	field.Type = consistent[0].Type.Enum()
+	delete(s.provisionalFields, field)
+	entry.Refined = typeName(field.GetType())
+	entry.Ambiguous = len(consistent) > 1
+	s.noteFieldDiscovery(field, "type refined to %s, as the server accepted %s", entry.Refined, describeAccepted(entry.Accepted))
+	return entry, true
+}
+
+func describeAccepted(accepted map[string]bool) string {
+	var values []string
+	for _, boundary := range boundaryValues {
+		if accepted[boundary.Name] {
+			values = append(values, boundary.Name)
+		}
+	}
+	if len(values) == 0 {
+		return "none of the boundary values"
+	}
+	return strings.Join(values, ", ")
+}
+
+func typeName(fieldType descriptorpb.FieldDescriptorProto_Type) string {
+	return strings.ToLower(strings.TrimPrefix(fieldType.String(), "TYPE_"))
+}
@@ -0,0 +1,80 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"req2proto/gapitest"
+	"strings"
+	"testing"
+)
+
+// firstViolationTransport makes the server report only the first violation once the refinement started, which is
+// when the first boundary value is sent. The crawl needs every violation, so it's left alone.
+type firstViolationTransport struct {
+	Transport
+	server *gapitest.Server
+}
+
+func (t *firstViolationTransport) Do(ctx context.Context, req *Request) (*Response, error) {
+	if bytes.Contains(req.Body, []byte("2147483648")) {
+		t.server.MaxViolations = 1
+	}
+	return t.Transport.Do(ctx, req)
+}
+
+func TestProbeTypeRefinement(t *testing.T) {
+	for _, firstViolationOnly := range []bool{false, true} {
+		server, err := gapitest.NewServer(loadTestSchema(t), testRequest)
+		if err != nil {
+			t.Fatalf("unable to create fake server: %v", err)
+		}
+		// the violations don't name the type, so every numeric field is a double until it's refined
+		server.UntypedViolations = true
+
+		var transport Transport = NewHandlerTransport(server)
+		if firstViolationOnly {
+			transport = &firstViolationTransport{Transport: transport, server: server}
+		}
+
+		var report []TypeRefinement
+		p := New(WithTransport(transport), WithRetries(0), WithTypeRefinement(func(r []TypeRefinement) {
+			report = r
+		}))
+		set, err := p.Probe(context.Background(), testURL, testRequest)
+		if err != nil {
+			t.Fatalf("probe failed: %v", err)
+		}
+
+		entries := make(map[string]TypeRefinement, len(report))
+		for _, entry := range report {
+			entries[entry.Field] = entry
+		}
+
+		fields := describeSchema(set.File, testRequest)[testRequest]
+		for i, want := range []struct {
+			field     string
+			refined   string
+			ambiguous bool
+		}{
+			{"count", "int32", false},
+			{"size", "int64", false},
+			{"id", "uint64", false},
+			// double accepts every boundary value, like a server that doesn't check the range at all
+			{"score", "double", true},
+			{"ratio", "float", false},
+		} {
+			entry, ok := entries[testRequest+"."+want.field]
+			if !ok {
+				t.Errorf("first violation only %v: %s isn't reported", firstViolationOnly, want.field)
+				continue
+			}
+			if entry.Reported != "unknown" || entry.Refined != want.refined || entry.Ambiguous != want.ambiguous {
+				t.Errorf("first violation only %v: %s: want unknown refined to %s (ambiguous %v), got %+v", firstViolationOnly, want.field, want.refined, want.ambiguous, entry)
+			}
+			// fields 2 to 6
+			if wantField := "optional " + want.refined + " " + want.field; len(fields) < i+2 || !strings.HasPrefix(fields[i+1], wantField) {
+				t.Errorf("first violation only %v: want %s, got %v", firstViolationOnly, wantField, fields)
+			}
+		}
+	}
+}
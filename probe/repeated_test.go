@@ -0,0 +1,74 @@
+package probe
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestProbeRepeatedFields(t *testing.T) {
+	set, err := probeTestSchema(t, context.Background())
+	if err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	diffDescriptions(t, describeSchema(loadTestSchema(t).File, testRequest), describeSchema(set.File, testRequest))
+
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		t.Fatalf("output doesn't resolve: %v", err)
+	}
+	desc, err := files.FindDescriptorByName(testRequest)
+	if err != nil {
+		t.Fatalf("no request message: %v", err)
+	}
+	request := desc.(protoreflect.MessageDescriptor)
+
+	for _, want := range []struct {
+		name string
+		list bool
+		kind protoreflect.Kind
+	}{
+		{"tags", true, protoreflect.StringKind},
+		{"ranks", true, protoreflect.Int32Kind},
+		{"audiences", true, protoreflect.EnumKind},
+		// the type name isn't the field name
+		{"items", true, protoreflect.MessageKind},
+		{"item", false, protoreflect.MessageKind},
+		{"visibility", false, protoreflect.EnumKind},
+	} {
+		field := request.Fields().ByName(protoreflect.Name(want.name))
+		if field == nil {
+			t.Errorf("field %s is missing", want.name)
+			continue
+		}
+		if field.IsList() != want.list || field.Kind() != want.kind {
+			t.Errorf("field %s: want list %v of %v, got list %v of %v", want.name, want.list, want.kind, field.IsList(), field.Kind())
+		}
+	}
+
+	quotas := request.Fields().ByName("quotas")
+	if quotas == nil || !quotas.IsMap() || quotas.MapKey().Kind() != protoreflect.StringKind || quotas.MapValue().Kind() != protoreflect.Int64Kind {
+		t.Errorf("quotas: want map<string, int64>, got %v", quotas)
+	}
+}
+
+func TestRepeatedFieldNumbers(t *testing.T) {
+	// violations of a list payload ([[1], [2], ...]) for the test schema
+	violations := []FieldViolation{
+		{Field: "name", Description: "Invalid value (), Unexpected list for single non-message field."},
+		{Field: "item.title", Description: "Invalid value at 'item.title' (TYPE_STRING), 9"},
+		{Field: "tags[0]", Description: "Invalid value at 'tags[0]' (TYPE_STRING), 16"},
+		{Field: "audiences[0]", Description: `Invalid value at 'audiences[0]' (type.googleapis.com/google.internal.test.v1.CreateItemRequest.Visibility), "x18"`},
+		{Field: "items[0]", Description: "Invalid value at 'items[0]' (type.googleapis.com/google.internal.test.v1.Item), 19"},
+		{Field: "20[0]", Description: `Invalid value at '20[0]' (type.googleapis.com/google.internal.test.v1.CreateItemRequest.QuotasEntry), 20`},
+		{Field: "ranks[0]", Description: `Invalid value at 'ranks[0]', "x17"`},
+	}
+
+	want := map[int]bool{16: true, 17: true, 18: true, 19: true, 20: true}
+	if got := repeatedFieldNumbers(violations); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,537 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+var digitsRe = regexp.MustCompile(`^-?[0-9]+$`)
+
+// jsonObject is a JSON object with its keys in order, which is the order of the field numbers
+type jsonObject struct {
+	keys   []string
+	values map[string]json.RawMessage
+}
+
+// jspbEntry is a set position of a JSPB message
+type jspbEntry struct {
+	number int32
+	value  interface{}
+}
+
+// responseTypeName names the response after the rpc method if the server named it, or after the request otherwise
+func (s *session) responseTypeName() string {
+	if s.rpcMethod != "" {
+		if packageName, _, methodName, err := splitMethod(s.rpcMethod); err == nil {
+			return packageName + "." + methodName + "Response"
+		}
+	}
+	return strings.TrimSuffix(s.rootMessage, "Request") + "Response"
+}
+
+// inferResponse sends the valid request body of WithResponse, and infers the response message from the JSPB reply.
+// Positions are field numbers, nested arrays are messages or repeated fields, and the other values are scalars. When
+// the server answers the same request in JSON as well, the fields are named after it and their shapes are checked
+// against it, otherwise they're named after their number.
+func (s *session) inferResponse() error {
+	jspbURL, err := withAltParameter(s.url, "protojson")
+	if err != nil {
+		return err
+	}
+	resp, err := s.sendTo(jspbURL, s.responseBody)
+	if err != nil {
+		return fmt.Errorf("unable to get response: %w", err)
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("the request body for the response isn't valid, status code %d: %s", resp.StatusCode, resp.Body)
+	}
+	if !strings.Contains(resp.Header.Get("Content-Type"), "application/json+protobuf") {
+		return fmt.Errorf("the server answered with %s instead of protojson", resp.Header.Get("Content-Type"))
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(resp.Body))
+	decoder.UseNumber()
+	var values []interface{}
+	if err := decoder.Decode(&values); err != nil {
+		return fmt.Errorf("unable to parse protojson response: %w", err)
+	}
+
+	// the names are a bonus, the response can be inferred without them
+	var named *jsonObject
+	jsonURL, err := withAltParameter(s.url, "json")
+	if err != nil {
+		return err
+	}
+	if resp, err := s.sendTo(jsonURL, s.responseBody); err != nil {
+		s.logger.Warn().Err(err).Msg("unable to get json response, the response fields are left unnamed")
+	} else if resp.StatusCode != 200 || !strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
+		s.logger.Warn().Int("status", resp.StatusCode).Msg("no json response, the response fields are left unnamed")
+	} else {
+		named = parseJSONObject(resp.Body)
+	}
+
+	responseType := s.responseTypeName()
+	x := messageRe.FindStringSubmatch(responseType)
+	if x == nil {
+		return fmt.Errorf("invalid response type name %q", responseType)
+	}
+	packageName, messageName := x[1], x[2]
+
+	msg := &descriptorpb.DescriptorProto{Name: proto.String(messageName)}
+	s.addResponseFields(msg, responseType, values, named)
+
+	fdproto, ok := s.packageFDProtoMap[packageName]
+	if !ok {
+		fdproto = &descriptorpb.FileDescriptorProto{
+			Name:    proto.String(FileName(packageName)),
+			Syntax:  proto.String("proto3"),
+			Package: proto.String(packageName),
+		}
+		s.packageFDProtoMap[packageName] = fdproto
+	}
+	fdproto.MessageType = mergeMessage(fdproto.MessageType, msg)
+
+	s.logger.Info().Str("type", responseType).Int("fields", len(msg.Field)).Bool("named", named != nil).Msg("response inferred")
+	return nil
+}
+
+// addResponseFields adds the fields set in a JSPB message to msg, whose full name is fullName. Fields that are
+// already there are only descended into, so the elements of a repeated message add up.
+func (s *session) addResponseFields(msg *descriptorpb.DescriptorProto, fullName string, values []interface{}, named *jsonObject) {
+	entries := jspbEntries(values)
+	names := correlateNames(entries, named)
+
+	for _, entry := range entries {
+		var jsonValue json.RawMessage
+		name := unnamedFieldName(entry.number)
+		jsonName := fmt.Sprintf("field%d", entry.number)
+		if key, ok := names[entry.number]; ok {
+			name, jsonName = snakeCase(key), key
+			jsonValue = named.values[key]
+		}
+
+		field := findFieldByNumber(msg, entry.number)
+		if field == nil {
+			field = &descriptorpb.FieldDescriptorProto{
+				Name:     proto.String(name),
+				Number:   proto.Int32(entry.number),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				JsonName: proto.String(jsonName),
+			}
+			if s.setResponseFieldType(msg, fullName, field, entry.value, jsonValue) {
+				msg.Field = append(msg.Field, field)
+				s.noteFieldDiscovery(field, "inferred from the response at position %d: %s", entry.number, truncate(entry.value))
+			}
+		}
+
+		// descend into messages, every element of a repeated message can set other fields
+		if field.GetType() != descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+			continue
+		}
+		nested := findNestedMessage(msg, strings.TrimPrefix(field.GetTypeName(), "."+fullName+"."))
+		if nested == nil {
+			continue
+		}
+		nestedName := strings.TrimPrefix(field.GetTypeName(), ".")
+		if field.GetLabel() != descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+			if values, ok := entry.value.([]interface{}); ok {
+				s.addResponseFields(nested, nestedName, values, parseJSONObject(jsonValue))
+			}
+			continue
+		}
+
+		elements, _ := entry.value.([]interface{})
+		var jsonElements []json.RawMessage
+		json.Unmarshal(jsonValue, &jsonElements)
+		for i, element := range elements {
+			values, ok := element.([]interface{})
+			if !ok {
+				continue
+			}
+			var jsonElement json.RawMessage
+			if i < len(jsonElements) {
+				jsonElement = jsonElements[i]
+			}
+			s.addResponseFields(nested, nestedName, values, parseJSONObject(jsonElement))
+		}
+	}
+}
+
+// setResponseFieldType sets the type of a new field from its JSPB value, and from its JSON value if there is one.
+// Nested messages and enums are declared in msg. It returns false if the value tells nothing.
+func (s *session) setResponseFieldType(msg *descriptorpb.DescriptorProto, fullName string, field *descriptorpb.FieldDescriptorProto, value interface{}, jsonValue json.RawMessage) bool {
+	list, ok := value.([]interface{})
+	if !ok {
+		return s.setScalarType(msg, fullName, field, value, jsonValue)
+	}
+
+	// a message is an object in JSON and a list is an array. Without JSON, a message usually has unset positions and
+	// a list of messages is an array of arrays, anything else is taken as a list of scalars.
+	repeated := false
+	switch jsonKind(jsonValue) {
+	case "array":
+		repeated = true
+	case "object":
+	default:
+		repeated = len(list) > 0
+		for _, element := range list {
+			if element == nil || jspbKind(element) != jspbKind(list[0]) {
+				repeated = false
+			}
+		}
+	}
+
+	if !repeated {
+		field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+		field.TypeName = proto.String("." + fullName + "." + s.declareNestedMessage(msg, field))
+		return true
+	}
+
+	if len(list) == 0 {
+		return false
+	}
+	field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	if _, ok := list[0].([]interface{}); ok {
+		field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+		field.TypeName = proto.String("." + fullName + "." + s.declareNestedMessage(msg, field))
+		return true
+	}
+
+	var jsonElements []json.RawMessage
+	json.Unmarshal(jsonValue, &jsonElements)
+	var jsonElement json.RawMessage
+	if len(jsonElements) > 0 {
+		jsonElement = jsonElements[0]
+	}
+	return s.setScalarType(msg, fullName, field, list[0], jsonElement)
+}
+
+// setScalarType guesses the type of a scalar. Integers that fit are int32, as the response can't tell more. A number
+// that is a name in JSON is an enum, and one that is a string in JSON is a 64-bit integer. JSPB sends 64-bit integers
+// as strings as well, so a string holding an integer is taken as one, unless JSON tells otherwise.
+func (s *session) setScalarType(msg *descriptorpb.DescriptorProto, fullName string, field *descriptorpb.FieldDescriptorProto, value interface{}, jsonValue json.RawMessage) bool {
+	var jsonString string
+	isJSONString := json.Unmarshal(jsonValue, &jsonString) == nil
+
+	switch value := value.(type) {
+	case bool:
+		field.Type = descriptorpb.FieldDescriptorProto_TYPE_BOOL.Enum()
+	case string:
+		field.Type = descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()
+		switch {
+		case jsonKind(jsonValue) == "number":
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum()
+		case jsonValue == nil || isJSONString && jsonString == value:
+			if typ, ok := integerStringType(value); ok {
+				field.Type = typ.Enum()
+				s.noteFieldDiscovery(field, "64-bit integer, as JSPB sends those as strings, it may be a string of digits as well")
+			}
+		}
+	case json.Number:
+		n, err := value.Int64()
+		switch {
+		case err != nil:
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_DOUBLE.Enum()
+		case isJSONString && !digitsRe.MatchString(jsonString):
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_ENUM.Enum()
+			field.TypeName = proto.String("." + fullName + "." + declareNestedEnum(msg, field, jsonString, int32(n)))
+		case isJSONString || n > math.MaxInt32 || n < math.MinInt32:
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum()
+		default:
+			field.Type = descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum()
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// integerStringType returns int64 for a string holding an integer as JSPB writes it (no sign or leading zeros
+// that a number wouldn't have), or uint64 if it only fits that
+func integerStringType(value string) (descriptorpb.FieldDescriptorProto_Type, bool) {
+	if !digitsRe.MatchString(value) {
+		return 0, false
+	}
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil && strconv.FormatInt(n, 10) == value {
+		return descriptorpb.FieldDescriptorProto_TYPE_INT64, true
+	}
+	if n, err := strconv.ParseUint(value, 10, 64); err == nil && strconv.FormatUint(n, 10) == value {
+		return descriptorpb.FieldDescriptorProto_TYPE_UINT64, true
+	}
+	return 0, false
+}
+
+// declareNestedMessage declares the type of a message field in msg, named after the field
+func (s *session) declareNestedMessage(msg *descriptorpb.DescriptorProto, field *descriptorpb.FieldDescriptorProto) string {
+	name := camelCase(field.GetName())
+	if findNestedMessage(msg, name) == nil {
+		msg.NestedType = append(msg.NestedType, &descriptorpb.DescriptorProto{Name: proto.String(name)})
+	}
+	return name
+}
+
+// declareNestedEnum declares the type of an enum field in msg, named after the field, with the value that was seen.
+// proto3 enums need a zero value, which is added if it wasn't the one seen. Enum values share the scope of msg with
+// the values of the sibling enums, so a name that is taken already is prefixed with the field name.
+func declareNestedEnum(msg *descriptorpb.DescriptorProto, field *descriptorpb.FieldDescriptorProto, valueName string, number int32) string {
+	name := camelCase(field.GetName())
+	prefix := strings.ToUpper(snakeCase(field.GetName()))
+
+	taken := make(map[string]bool)
+	for _, enum := range msg.EnumType {
+		for _, value := range enum.Value {
+			taken[value.GetName()] = true
+		}
+	}
+	for _, nested := range msg.NestedType {
+		taken[nested.GetName()] = true
+	}
+
+	enum := findEnum(&msg.EnumType, name)
+	if enum == nil {
+		enum = &descriptorpb.EnumDescriptorProto{Name: proto.String(name)}
+		msg.EnumType = append(msg.EnumType, enum)
+	}
+
+	if number != 0 {
+		enum.Value = append(enum.Value, &descriptorpb.EnumValueDescriptorProto{
+			Name:   proto.String(prefix + "_UNSPECIFIED"),
+			Number: proto.Int32(0),
+		})
+		taken[prefix+"_UNSPECIFIED"] = true
+	}
+	if taken[valueName] {
+		valueName = prefix + "_" + valueName
+	}
+	enum.Value = append(enum.Value, &descriptorpb.EnumValueDescriptorProto{Name: proto.String(valueName), Number: proto.Int32(number)})
+	sort.Slice(enum.Value, func(i, j int) bool {
+		return enum.Value[i].GetNumber() < enum.Value[j].GetNumber()
+	})
+	return name
+}
+
+// jspbEntries returns the set positions of a JSPB message in order, including the ones of a trailing object with
+// the positions that don't fit in the array
+func jspbEntries(values []interface{}) []jspbEntry {
+	var entries []jspbEntry
+	for i, value := range values {
+		if value == nil {
+			continue
+		}
+		if sparse, ok := value.(map[string]interface{}); ok && i == len(values)-1 {
+			for key, value := range sparse {
+				if number, err := strconv.Atoi(key); err == nil && value != nil {
+					entries = append(entries, jspbEntry{number: int32(number), value: value})
+				}
+			}
+			continue
+		}
+		entries = append(entries, jspbEntry{number: int32(i + 1), value: value})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].number < entries[j].number
+	})
+	return entries
+}
+
+// correlateNames maps the field numbers of a JSPB message to the keys of the same message in JSON. Both list the set
+// fields in the order of their numbers, so they're paired one by one if their count and kinds agree. Otherwise,
+// scalars are paired by value when it's unique.
+func correlateNames(entries []jspbEntry, named *jsonObject) map[int32]string {
+	names := make(map[int32]string)
+	if named == nil {
+		return names
+	}
+
+	if len(entries) == len(named.keys) {
+		paired := true
+		for i, entry := range entries {
+			if !compatibleKinds(jspbKind(entry.value), jsonKind(named.values[named.keys[i]])) {
+				paired = false
+				break
+			}
+		}
+		if paired {
+			for i, entry := range entries {
+				names[entry.number] = named.keys[i]
+			}
+			return names
+		}
+	}
+
+	used := make(map[string]bool)
+	for _, entry := range entries {
+		if kind := jspbKind(entry.value); kind == "array" || kind == "object" {
+			continue
+		}
+
+		var match string
+		matches := 0
+		for _, key := range named.keys {
+			if !used[key] && scalarText(named.values[key]) == fmt.Sprint(entry.value) {
+				match = key
+				matches++
+			}
+		}
+		if matches == 1 {
+			names[entry.number] = match
+			used[match] = true
+		}
+	}
+	return names
+}
+
+func compatibleKinds(jspb string, json string) bool {
+	switch {
+	case jspb == json:
+		return true
+	case jspb == "array" && json == "object":
+		return true
+	// 64-bit integers and enums can be strings on one side and numbers on the other
+	case (jspb == "number" || jspb == "string") && (json == "number" || json == "string"):
+		return true
+	}
+	return false
+}
+
+func jspbKind(value interface{}) string {
+	switch value.(type) {
+	case bool:
+		return "bool"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "null"
+}
+
+func jsonKind(value json.RawMessage) string {
+	value = bytes.TrimSpace(value)
+	if len(value) == 0 {
+		return ""
+	}
+	switch value[0] {
+	case '"':
+		return "string"
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case 't', 'f':
+		return "bool"
+	case 'n':
+		return "null"
+	}
+	return "number"
+}
+
+// scalarText returns a JSON scalar the way fmt prints the decoded JSPB value, strings without quotes
+func scalarText(value json.RawMessage) string {
+	var s string
+	if json.Unmarshal(value, &s) == nil {
+		return s
+	}
+	return string(bytes.TrimSpace(value))
+}
+
+// parseJSONObject parses a JSON object keeping the order of its keys, or returns nil if it isn't one
+func parseJSONObject(raw json.RawMessage) *jsonObject {
+	if jsonKind(raw) != "object" {
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	if _, err := decoder.Token(); err != nil {
+		return nil
+	}
+
+	obj := &jsonObject{values: make(map[string]json.RawMessage)}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil
+		}
+		key, ok := token.(string)
+		if !ok {
+			return nil
+		}
+
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil
+		}
+		if jsonKind(value) == "null" {
+			continue
+		}
+		obj.keys = append(obj.keys, key)
+		obj.values[key] = value
+	}
+	return obj
+}
+
+func findNestedMessage(msg *descriptorpb.DescriptorProto, name string) *descriptorpb.DescriptorProto {
+	for _, nested := range msg.NestedType {
+		if nested.GetName() == name {
+			return nested
+		}
+	}
+	return nil
+}
+
+// snakeCase turns a lowerCamel JSON name into a field name, ex. nextPageToken into next_page_token
+func snakeCase(name string) string {
+	var result strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				result.WriteRune('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		result.WriteRune(r)
+	}
+	return result.String()
+}
+
+// camelCase turns a field name into a type name, ex. primary_tag into PrimaryTag
+func camelCase(name string) string {
+	var result strings.Builder
+	upper := true
+	for _, r := range name {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		result.WriteRune(r)
+	}
+	return result.String()
+}
+
+// truncate formats a value for a discovery note, keeping it short
+func truncate(value interface{}) string {
+	b, _ := json.Marshal(value)
+	if len(b) > 40 {
+		return string(b[:40]) + "..."
+	}
+	return string(b)
+}
@@ -0,0 +1,183 @@
+package probe
+
+import (
+	"context"
+	"net/http"
+	"req2proto/gapitest"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const testResponse = "google.internal.test.v1.CreateItemResponse"
+
+// newResponseServer serves the test schema, answering valid requests with a CreateItemResponse
+func newResponseServer(t *testing.T) *gapitest.Server {
+	t.Helper()
+
+	files, err := LoadDescriptorPath("testdata/schema")
+	if err != nil {
+		t.Fatalf("unable to load test schema: %v", err)
+	}
+	var desc protoreflect.MessageDescriptor
+	for _, fd := range files {
+		if md := fd.Messages().ByName("CreateItemResponse"); md != nil {
+			desc = md
+		}
+	}
+	if desc == nil {
+		t.Fatalf("unable to find %s", testResponse)
+	}
+
+	response := dynamicpb.NewMessage(desc)
+	err = protojson.Unmarshal([]byte(`{
+		"item": {"title": "hello", "primaryTag": {"key": "k", "weight": 3}, "text": "body"},
+		"name": "items/1",
+		"version": "1234567890123",
+		"created": true,
+		"visibility": "PRIVATE",
+		"warnings": ["a", "b"],
+		"tags": [{"key": "x"}, {"key": "y", "weight": 2}],
+		"score": 0.5,
+		"audience": "PRIVATE"
+	}`), response)
+	if err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+
+	server, err := gapitest.NewServer(loadTestSchema(t), testRequest)
+	if err != nil {
+		t.Fatalf("unable to create fake server: %v", err)
+	}
+	server.Response = response
+	return server
+}
+
+func TestProbeResponse(t *testing.T) {
+	server := newResponseServer(t)
+	p := New(WithTransport(NewHandlerTransport(server)), WithRetries(0), WithResponse([]byte(`["name"]`)))
+	set, err := p.Probe(context.Background(), testURL, testRequest)
+	if err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+
+	// the types of nested messages can't be told, so they're declared in the response. fixed32 looks like int32.
+	diffDescriptions(t, map[string][]string{
+		testResponse: {
+			"optional google.internal.test.v1.CreateItemResponse.Item item = 1",
+			"optional string name = 2",
+			"optional int64 version = 3",
+			"optional bool created = 4",
+			"optional google.internal.test.v1.CreateItemResponse.Visibility visibility = 5",
+			"repeated string warnings = 6",
+			"repeated google.internal.test.v1.CreateItemResponse.Tags tags = 7",
+			"optional double score = 8",
+			"optional google.internal.test.v1.CreateItemResponse.Audience audience = 9",
+		},
+		testResponse + ".Item": {
+			"optional string title = 1",
+			"optional google.internal.test.v1.CreateItemResponse.Item.PrimaryTag primary_tag = 2",
+			"optional string text = 3",
+		},
+		testResponse + ".Item.PrimaryTag": {
+			"optional string key = 1",
+			"optional int32 weight = 2",
+		},
+		testResponse + ".Tags": {
+			"optional string key = 1",
+			"optional int32 weight = 2",
+		},
+	}, describeSchema(set.File, testResponse))
+
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		t.Fatalf("output doesn't resolve: %v", err)
+	}
+	desc, _ := files.FindDescriptorByName(testResponse)
+	response := desc.(protoreflect.MessageDescriptor)
+	if got := response.Fields().ByName("version").JSONName(); got != "version" {
+		t.Errorf("json name of version: want version, got %s", got)
+	}
+	if got := response.Fields().ByName("visibility").Enum().Values().ByNumber(2); got == nil || got.Name() != "PRIVATE" {
+		t.Errorf("visibility: want PRIVATE = 2, got %v", got)
+	}
+	// enum values are scoped to the response like the enums, so the second PRIVATE is prefixed
+	if got := response.Fields().ByName("audience").Enum().Values().ByNumber(2); got == nil || got.Name() != "AUDIENCE_PRIVATE" {
+		t.Errorf("audience: want AUDIENCE_PRIVATE = 2, got %v", got)
+	}
+
+	// the request is probed the same as without the response
+	diffDescriptions(t, describeSchema(loadTestSchema(t).File, testRequest), describeSchema(set.File, testRequest))
+}
+
+func TestProbeResponseWithoutJSON(t *testing.T) {
+	server := newResponseServer(t)
+	// the server only answers in protojson, so the fields can't be named
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("alt") == "json" {
+			http.NotFound(w, r)
+			return
+		}
+		server.ServeHTTP(w, r)
+	})
+
+	p := New(WithTransport(NewHandlerTransport(handler)), WithRetries(0), WithResponse([]byte(`["name"]`)))
+	set, err := p.Probe(context.Background(), testURL, testRequest)
+	if err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+
+	// arrays of a single kind are taken as repeated, so only the messages with mixed fields are told apart
+	diffDescriptions(t, map[string][]string{
+		testResponse: {
+			"optional google.internal.test.v1.CreateItemResponse.Field1 field_1 = 1",
+			"optional string field_2 = 2",
+			"optional int64 field_3 = 3",
+			"optional bool field_4 = 4",
+			"optional int32 field_5 = 5",
+			"repeated string field_6 = 6",
+			"repeated google.internal.test.v1.CreateItemResponse.Field7 field_7 = 7",
+			"optional double field_8 = 8",
+			"optional int32 field_9 = 9",
+		},
+		testResponse + ".Field1": {
+			"optional string field_1 = 1",
+			"optional google.internal.test.v1.CreateItemResponse.Field1.Field2 field_2 = 2",
+			"optional string field_3 = 3",
+		},
+		testResponse + ".Field1.Field2": {
+			"optional string field_1 = 1",
+			"optional int32 field_2 = 2",
+		},
+		testResponse + ".Field7": {
+			"optional string field_1 = 1",
+			"optional int32 field_2 = 2",
+		},
+	}, describeSchema(set.File, testResponse))
+}
+
+func TestIntegerStringType(t *testing.T) {
+	tests := map[string]descriptorpb.FieldDescriptorProto_Type{
+		"1234567890123":        descriptorpb.FieldDescriptorProto_TYPE_INT64,
+		"-42":                  descriptorpb.FieldDescriptorProto_TYPE_INT64,
+		"18446744073709551615": descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+		// not the way a number is written, ex. a phone number
+		"0123": 0,
+		"+1":   0,
+		"1e3":  0,
+		"x1":   0,
+		// too large for 64 bits
+		"18446744073709551616": 0,
+	}
+
+	for value, want := range tests {
+		got, ok := integerStringType(value)
+		if ok != (want != 0) || got != want {
+			t.Errorf("%s: want %v, got %v", value, want, got)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package probe
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const (
+	annotationsFile = "google/api/annotations.proto"
+	// google.api.http, an extension of google.protobuf.MethodOptions
+	httpRuleExtension = 72295728
+)
+
+// trimmed copies of google/api/annotations.proto and http.proto, which the services import for their http rules
+//
+//go:embed googleapis
+var googleAPIProtos embed.FS
+
+var (
+	googleAPIOnce  sync.Once
+	googleAPIFiles *protoregistry.Files
+	googleAPIErr   error
+)
+
+// openGoogleAPIProto opens an embedded google/api file by its import path
+func openGoogleAPIProto(path string) (io.ReadCloser, error) {
+	return googleAPIProtos.Open("googleapis/" + path)
+}
+
+// googleAPIRegistry compiles the embedded google/api files the first time it's called
+func googleAPIRegistry() (*protoregistry.Files, error) {
+	googleAPIOnce.Do(func() {
+		compiler := protocompile.Compiler{
+			Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{Accessor: openGoogleAPIProto}),
+		}
+		compiled, err := compiler.Compile(context.Background(), annotationsFile)
+		if err != nil {
+			googleAPIErr = fmt.Errorf("unable to compile %s: %w", annotationsFile, err)
+			return
+		}
+
+		googleAPIFiles = &protoregistry.Files{}
+		for _, fd := range compiled {
+			if err := registerFileWithImports(googleAPIFiles, fd); err != nil {
+				googleAPIErr = err
+				return
+			}
+		}
+	})
+	return googleAPIFiles, googleAPIErr
+}
+
+// addServiceMethod adds the rpc of the endpoint to the service named by the ErrorInfo, in the service file of its
+// package. The response isn't probed, so an empty <Method>Response is declared next to the service if it isn't
+// defined anywhere.
+func (s *session) addServiceMethod() error {
+	if s.rpcMethod == "" {
+		s.logger.Warn().Str("url", s.url).Msg("the server didn't name the rpc method, no service is generated for the endpoint")
+		return nil
+	}
+
+	// the annotation is part of the output, so its definition has to be as well
+	if _, err := googleAPIRegistry(); err != nil {
+		return err
+	}
+
+	packageName, serviceName, methodName, err := splitMethod(s.rpcMethod)
+	if err != nil {
+		return err
+	}
+
+	fdproto, ok := s.serviceFDProtoMap[packageName]
+	if !ok {
+		fdproto = &descriptorpb.FileDescriptorProto{
+			Name:    proto.String(ServiceFileName(packageName)),
+			Syntax:  proto.String("proto3"),
+			Package: proto.String(packageName),
+		}
+		s.serviceFDProtoMap[packageName] = fdproto
+	}
+
+	var service *descriptorpb.ServiceDescriptorProto
+	for _, sd := range fdproto.Service {
+		if sd.GetName() == serviceName {
+			service = sd
+		}
+	}
+	if service == nil {
+		service = &descriptorpb.ServiceDescriptorProto{Name: proto.String(serviceName)}
+		fdproto.Service = append(fdproto.Service, service)
+	}
+
+	rule, err := s.httpRule()
+	if err != nil {
+		return err
+	}
+	options := &descriptorpb.MethodOptions{}
+	options.ProtoReflect().SetUnknown(protowire.AppendBytes(protowire.AppendTag(nil, httpRuleExtension, protowire.BytesType), rule))
+
+	responseType := packageName + "." + methodName + "Response"
+	method := &descriptorpb.MethodDescriptorProto{
+		Name:       proto.String(methodName),
+		InputType:  proto.String("." + s.rootMessage),
+		OutputType: proto.String("." + responseType),
+		Options:    options,
+	}
+
+	// an endpoint probed again replaces its rpc
+	replaced := false
+	for i, md := range service.Method {
+		if md.GetName() == methodName {
+			service.Method[i] = method
+			replaced = true
+		}
+	}
+	if !replaced {
+		service.Method = append(service.Method, method)
+	}
+
+	dependencies := []string{annotationsFile}
+	for _, typeName := range []string{s.rootMessage, responseType} {
+		file, ok := s.typeFile(typeName)
+		if !ok && typeName == responseType {
+			if !hasMessage(fdproto.MessageType, methodName+"Response") {
+				fdproto.MessageType = append(fdproto.MessageType, &descriptorpb.DescriptorProto{Name: proto.String(methodName + "Response")})
+			}
+			continue
+		}
+		if ok && file != fdproto.GetName() {
+			dependencies = append(dependencies, file)
+		}
+	}
+	for _, dep := range dependencies {
+		if !containsString(fdproto.Dependency, dep) {
+			fdproto.Dependency = append(fdproto.Dependency, dep)
+		}
+	}
+	sort.Strings(fdproto.Dependency)
+
+	s.logger.Info().Str("service", packageName+"."+serviceName).Str("rpc", methodName).Msg("service method discovered")
+	return nil
+}
+
+// httpRule encodes the google.api.HttpRule of the endpoint, from its method and the path of its URL:
+//
+//	google.api.HttpRule { oneof pattern { string get = 2; string put = 3; string post = 4; string delete = 5;
+//	                      string patch = 6; CustomHttpPattern custom = 8; } string body = 7; }
+//	CustomHttpPattern   { string kind = 1; string path = 2; }
+func (s *session) httpRule() ([]byte, error) {
+	u, err := url.Parse(s.url)
+	if err != nil {
+		return nil, err
+	}
+	path := u.Path
+
+	var b []byte
+	method := strings.ToUpper(s.method)
+	switch method {
+	case "GET", "PUT", "POST", "DELETE", "PATCH":
+		number := map[string]protowire.Number{"GET": 2, "PUT": 3, "POST": 4, "DELETE": 5, "PATCH": 6}[method]
+		b = protowire.AppendTag(b, number, protowire.BytesType)
+		b = protowire.AppendString(b, path)
+	default:
+		var custom []byte
+		custom = protowire.AppendTag(custom, 1, protowire.BytesType)
+		custom = protowire.AppendString(custom, method)
+		custom = protowire.AppendTag(custom, 2, protowire.BytesType)
+		custom = protowire.AppendString(custom, path)
+		b = protowire.AppendTag(b, 8, protowire.BytesType)
+		b = protowire.AppendBytes(b, custom)
+	}
+
+	// the whole request is the body, as the payload is sent that way
+	if method != "GET" && method != "DELETE" {
+		b = protowire.AppendTag(b, 7, protowire.BytesType)
+		b = protowire.AppendString(b, "*")
+	}
+	return b, nil
+}
+
+// typeFile returns the file a message is defined in, either a probed package or an external file
+func (s *session) typeFile(typeName string) (string, bool) {
+	if x := messageRe.FindStringSubmatch(typeName); x != nil {
+		if fdproto, ok := s.packageFDProtoMap[x[1]]; ok {
+			messages, _ := collectSchema([]*descriptorpb.FileDescriptorProto{fdproto})
+			if _, ok := messages[typeName]; ok {
+				return fdproto.GetName(), true
+			}
+		}
+	}
+
+	if desc, ok := s.resolveExternalType(typeName); ok {
+		if _, ok := desc.(protoreflect.MessageDescriptor); ok {
+			return desc.ParentFile().Path(), true
+		}
+	}
+	return "", false
+}
+
+// serviceFiles returns the service files in a fixed order
+func (s *session) serviceFiles() []*descriptorpb.FileDescriptorProto {
+	packageNames := make([]string, 0, len(s.serviceFDProtoMap))
+	for p := range s.serviceFDProtoMap {
+		packageNames = append(packageNames, p)
+	}
+	sort.Strings(packageNames)
+
+	files := make([]*descriptorpb.FileDescriptorProto, 0, len(packageNames))
+	for _, p := range packageNames {
+		files = append(files, s.serviceFDProtoMap[p])
+	}
+	return files
+}
+
+func hasMessage(messages []*descriptorpb.DescriptorProto, name string) bool {
+	for _, msg := range messages {
+		if msg.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package probe
+
+import (
+	"context"
+	"net/http"
+	"req2proto/gapitest"
+	"req2proto/parser"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func TestProbeServices(t *testing.T) {
+	mux := http.NewServeMux()
+	for path, method := range map[string]string{"/v1/items:create": "CreateItem", "/v1/items:update": "UpdateItem"} {
+		requestType := "google.internal.test.v1." + method + "Request"
+		server, err := gapitest.NewServer(loadTestSchema(t), requestType)
+		if err != nil {
+			t.Fatalf("unable to create fake server: %v", err)
+		}
+		server.Method = "google.internal.test.v1.ItemService." + method
+		mux.Handle(path, server)
+	}
+
+	p := New(WithTransport(NewHandlerTransport(mux)), WithRetries(0), WithServices())
+	set, results := p.ProbeBatch(context.Background(), []Endpoint{
+		{URL: testURL},
+		{URL: "https://test-pa.googleapis.com/v1/items:update?alt=json", Options: []Option{WithMethod("PATCH")}},
+	})
+	for _, result := range results {
+		if result.Err != nil {
+			t.Fatalf("endpoint %s failed: %v", result.Endpoint.URL, result.Err)
+		}
+	}
+
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		t.Fatalf("output doesn't resolve: %v", err)
+	}
+	fd, err := files.FindFileByPath("google/internal/test/v1/service.proto")
+	if err != nil {
+		t.Fatalf("no service file: %v", err)
+	}
+
+	service := fd.Services().ByName("ItemService")
+	if service == nil || service.Methods().Len() != 2 {
+		t.Fatalf("want ItemService with 2 rpcs, got %v", service)
+	}
+	for _, method := range []struct {
+		name   protoreflect.Name
+		input  protoreflect.FullName
+		output protoreflect.FullName
+	}{
+		{"CreateItem", testRequest, "google.internal.test.v1.CreateItemResponse"},
+		{"UpdateItem", updateRequest, "google.internal.test.v1.UpdateItemResponse"},
+	} {
+		md := service.Methods().ByName(method.name)
+		if md == nil {
+			t.Errorf("rpc %s is missing", method.name)
+			continue
+		}
+		if md.Input().FullName() != method.input || md.Output().FullName() != method.output {
+			t.Errorf("rpc %s: want (%s) returns (%s), got (%s) returns (%s)", method.name, method.input, method.output, md.Input().FullName(), md.Output().FullName())
+		}
+	}
+
+	generated := parser.GenerateProtoFile(fd)
+	for _, want := range []string{
+		"rpc CreateItem(google.internal.test.v1.CreateItemRequest) returns (CreateItemResponse) {",
+		`post: "/v1/items:create"`,
+		`patch: "/v1/items:update"`,
+		`body: "*"`,
+	} {
+		if !strings.Contains(generated, want) {
+			t.Errorf("generated service.proto doesn't contain %q:\n%s", want, generated)
+		}
+	}
+}
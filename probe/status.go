@@ -0,0 +1,99 @@
+package probe
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// parseProtoErrorResponse extracts the field violations out of a binary google.rpc.Status.
+//
+// The messages involved are small enough that they're decoded by hand instead of pulling in googleapis:
+//
+//	google.rpc.Status     { int32 code = 1; string message = 2; repeated google.protobuf.Any details = 3; }
+//	google.protobuf.Any   { string type_url = 1; bytes value = 2; }
+//	google.rpc.BadRequest { repeated FieldViolation field_violations = 1; }
+//	FieldViolation        { string field = 1; string description = 2; }
+func parseProtoErrorResponse(body []byte) ([]FieldViolation, error) {
+	var violations []FieldViolation
+
+	err := rangeProtoFields(body, func(num protowire.Number, value []byte) error {
+		if num != 3 {
+			return nil
+		}
+
+		var typeURL string
+		var packed []byte
+		err := rangeProtoFields(value, func(num protowire.Number, value []byte) error {
+			switch num {
+			case 1:
+				typeURL = string(value)
+			case 2:
+				packed = value
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if typeURL != badRequestTypeURL {
+			return nil
+		}
+
+		return rangeProtoFields(packed, func(num protowire.Number, value []byte) error {
+			if num != 1 {
+				return nil
+			}
+
+			var violation FieldViolation
+			err := rangeProtoFields(value, func(num protowire.Number, value []byte) error {
+				switch num {
+				case 1:
+					violation.Field = string(value)
+				case 2:
+					violation.Description = string(value)
+				}
+				return nil
+			})
+			violations = append(violations, violation)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse proto status: %w", err)
+	}
+
+	return violations, nil
+}
+
+// rangeProtoFields calls fn for every length-delimited field in a serialized message. Other wire types are skipped.
+func rangeProtoFields(b []byte, fn func(num protowire.Number, value []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+
+		value, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if err := fn(num, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Request is a request to the API, sent through a Transport
+type Request struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+}
+
+// Response is the answer of the API to a Request
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Transport sends requests to the API. It has to be safe for concurrent use, as every worker sends through the same
+// Transport. Rate limits and retries are handled by the Prober, so a Transport only sends a request once.
+type Transport interface {
+	Do(ctx context.Context, req *Request) (*Response, error)
+}
+
+// fasthttpTransport is the default Transport
+type fasthttpTransport struct {
+	client *fasthttp.Client
+}
+
+// NewFastHTTPTransport returns a Transport sending requests with client, or a default fasthttp.Client if it's nil
+func NewFastHTTPTransport(client *fasthttp.Client) Transport {
+	if client == nil {
+		client = &fasthttp.Client{}
+	}
+	return &fasthttpTransport{client: client}
+}
+
+func (t *fasthttpTransport) Do(ctx context.Context, r *Request) (*Response, error) {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+
+	req.SetRequestURI(r.URL)
+	req.Header.SetMethod(r.Method)
+	for k, values := range r.Header {
+		for _, v := range values {
+			req.Header.Add(k, v)
+		}
+	}
+	req.SetBody(r.Body)
+
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+
+	// fasthttp doesn't take a context, the deadline is the closest it gets
+	var err error
+	if deadline, ok := ctx.Deadline(); ok {
+		err = t.client.DoDeadline(req, resp, deadline)
+	} else {
+		err = t.client.Do(req, resp)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	header := make(http.Header)
+	resp.Header.VisitAll(func(k, v []byte) {
+		header.Add(string(k), string(v))
+	})
+
+	// the response is released on return, so the body has to be copied
+	return &Response{StatusCode: resp.StatusCode(), Header: header, Body: append([]byte(nil), resp.Body()...)}, nil
+}
+
+// handlerTransport serves requests in-process, without going through the network
+type handlerTransport struct {
+	handler http.Handler
+}
+
+// NewHandlerTransport returns a Transport that answers requests with handler, ex. a fake API in tests
+func NewHandlerTransport(handler http.Handler) Transport {
+	return &handlerTransport{handler: handler}
+}
+
+func (t *handlerTransport) Do(ctx context.Context, r *Request) (*Response, error) {
+	req, err := http.NewRequestWithContext(ctx, r.Method, r.URL, bytes.NewReader(r.Body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = r.Header.Clone()
+
+	w := &responseBuffer{header: make(http.Header)}
+	t.handler.ServeHTTP(w, req)
+
+	return &Response{StatusCode: w.statusCode(), Header: w.header, Body: w.body.Bytes()}, nil
+}
+
+// responseBuffer is the http.ResponseWriter of handlerTransport, which keeps the response in memory
+type responseBuffer struct {
+	header http.Header
+	body   bytes.Buffer
+	status int
+}
+
+func (w *responseBuffer) Header() http.Header {
+	return w.header
+}
+
+func (w *responseBuffer) Write(b []byte) (int, error) {
+	// like net/http, writing the body without a status is a 200
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+func (w *responseBuffer) WriteHeader(statusCode int) {
+	// only the first status counts
+	if w.status == 0 {
+		w.status = statusCode
+	}
+}
+
+func (w *responseBuffer) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
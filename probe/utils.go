@@ -0,0 +1,20 @@
+package probe
+
+import (
+	"strings"
+	"unicode"
+)
+
+func convertToUnknownType(typeName string) string {
+	var result strings.Builder
+	result.WriteString("UNKNOWN_")
+
+	for i, r := range typeName {
+		if i > 0 && unicode.IsUpper(r) && !unicode.IsUpper(rune(typeName[i-1])) {
+			result.WriteRune('_')
+		}
+		result.WriteRune(unicode.ToUpper(r))
+	}
+
+	return result.String()
+}